@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v48/github"
+)
+
+const (
+	// AppJWTLifetime is how long each GitHub App JWT is valid for.  GitHub
+	// rejects JWTs that expire more than 10 minutes in the future.
+	AppJWTLifetime = 9 * time.Minute
+
+	// AppTokenRefreshMargin is how long before expiry an installation token
+	// is replaced, so that requests in flight never carry a stale token.
+	AppTokenRefreshMargin = 5 * time.Minute
+)
+
+// TokenSource supplies the credential that MyRoundTripper sends to GitHub.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticToken is a TokenSource for a fixed token, e.g. a personal access
+// token read from a file.
+type StaticToken string
+
+func (t StaticToken) Token(ctx context.Context) (string, error) {
+	return string(t), nil
+}
+
+// AppConfig identifies a GitHub App to authenticate as.  If InstallationID
+// is zero, the installation is looked up from the repository being mirrored.
+type AppConfig struct {
+	AppID          int64  `yaml:"appID"`
+	InstallationID int64  `yaml:"installationID,omitempty"`
+	PrivateKeyFile string `yaml:"privateKeyFile"`
+}
+
+func (app *AppConfig) Validate() error {
+	if app.AppID <= 0 {
+		return errors.New("app.appID: must be a positive integer")
+	}
+	if app.InstallationID < 0 {
+		return errors.New("app.installationID: must not be negative")
+	}
+	if app.PrivateKeyFile == "" {
+		return errors.New("app: missing required field \"privateKeyFile\"")
+	}
+	return nil
+}
+
+// LoadPrivateKey reads the PEM-encoded RSA private key of a GitHub App, in
+// either PKCS #1 (as downloaded from GitHub) or PKCS #8 form.
+func LoadPrivateKey(keyFile string) (*rsa.PrivateKey, error) {
+	raw, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM block found", keyFile)
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", keyFile, err)
+		}
+		return key, nil
+
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", keyFile, err)
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected an RSA private key, got %T", keyFile, key)
+		}
+		return rsaKey, nil
+
+	default:
+		return nil, fmt.Errorf("%s: unexpected PEM block of type %q", keyFile, block.Type)
+	}
+}
+
+// AppJWTSource is a TokenSource of JWTs signed with a GitHub App's private
+// key, which authenticate as the app itself.
+type AppJWTSource struct {
+	AppID int64
+	Key   *rsa.PrivateKey
+
+	mu      sync.Mutex
+	jwt     string
+	expires time.Time
+}
+
+func (s *AppJWTSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.jwt != "" && now.Add(time.Minute).Before(s.expires) {
+		return s.jwt, nil
+	}
+
+	// Backdate the JWT to allow for clock drift between us and GitHub.
+	issued := now.Add(-time.Minute)
+	expires := now.Add(AppJWTLifetime)
+	jwt, err := signJWT(s.Key, map[string]any{
+		"iat": issued.Unix(),
+		"exp": expires.Unix(),
+		"iss": strconv.FormatInt(s.AppID, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	s.jwt = jwt
+	s.expires = expires
+	return jwt, nil
+}
+
+// signJWT returns an RS256 JSON Web Token with the given claims.
+func signJWT(key *rsa.PrivateKey, claims map[string]any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(base64.RawURLEncoding.EncodeToString(header))
+	buf.WriteByte('.')
+	buf.WriteString(base64.RawURLEncoding.EncodeToString(payload))
+
+	digest := sha256.Sum256(buf.Bytes())
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	buf.WriteByte('.')
+	buf.WriteString(base64.RawURLEncoding.EncodeToString(sig))
+	return buf.String(), nil
+}
+
+// AppTokenSource is a TokenSource of GitHub App installation tokens.  A new
+// token is requested whenever the current one is close to expiring, so that
+// long syncs outlive the one hour lifetime of each token.
+type AppTokenSource struct {
+	InstallationID int64
+
+	// AppClient is authenticated as the app itself, i.e. with an
+	// AppJWTSource.
+	AppClient *github.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (s *AppTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Now().Add(AppTokenRefreshMargin).Before(s.expires) {
+		return s.token, nil
+	}
+
+	tok, _, err := s.AppClient.Apps.CreateInstallationToken(ctx, s.InstallationID, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create GitHub App installation token: %w", err)
+	}
+
+	s.token = tok.GetToken()
+	s.expires = tok.GetExpiresAt()
+	return s.token, nil
+}
+
+// NewAppTokenSource prepares to authenticate as an installation of app.  If
+// the installation ID is not configured, it is looked up from owner/repo.
+func NewAppTokenSource(ctx context.Context, app *AppConfig, transport http.RoundTripper, apiURL string, uploadURL string, owner string, repo string) (*AppTokenSource, error) {
+	key, err := LoadPrivateKey(app.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	appClient, _, err := NewAuthenticatedClient(transport, &AppJWTSource{AppID: app.AppID, Key: key}, apiURL, uploadURL)
+	if err != nil {
+		return nil, err
+	}
+
+	installationID := app.InstallationID
+	if installationID == 0 {
+		installation, _, err := appClient.Apps.FindRepositoryInstallation(ctx, owner, repo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find GitHub App installation for %s/%s: %w", owner, repo, err)
+		}
+		installationID = installation.GetID()
+	}
+
+	return &AppTokenSource{InstallationID: installationID, AppClient: appClient}, nil
+}
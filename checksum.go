@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"github.com/chronos-tachyon/github-asset-mirror/indexfile"
+	"github.com/chronos-tachyon/github-asset-mirror/indexutil"
+)
+
+// QuarantineDirName is the directory beneath each repository's output
+// directory where assets that fail verification are moved.
+const QuarantineDirName = ".quarantine"
+
+const (
+	FailOnMismatch       = "fail"
+	QuarantineOnMismatch = "quarantine"
+)
+
+var (
+	reChecksumGNU  = regexp.MustCompile(`^([0-9A-Fa-f]{64}|[0-9A-Fa-f]{128})(?:\s+[ *]?(.+))?$`)
+	reChecksumName = regexp.MustCompile(`(?i)^(.+)\.(?:sha256|sha512)(?:sum)?$`)
+	reChecksumBSD  = regexp.MustCompile(`^(SHA256|SHA512) ?\((.+)\) ?= ?([0-9A-Fa-f]{64}|[0-9A-Fa-f]{128})$`)
+)
+
+type ChecksumEntry struct {
+	Name   string
+	SHA256 string
+	SHA512 string
+}
+
+// ParseChecksumFile parses the contents of a checksum manifest in either GNU
+// coreutils ("<hex>  <name>") or BSD ("SHA256 (<name>) = <hex>") format.
+// Lines without a file name, as found in per-file ".sha256" assets, are
+// attributed to defaultName.
+func ParseChecksumFile(raw []byte, defaultName string) ([]ChecksumEntry, error) {
+	var out []ChecksumEntry
+	s := bufio.NewScanner(bytes.NewReader(raw))
+	lineNum := 0
+	for s.Scan() {
+		lineNum++
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var name, hexDigest string
+		if match := reChecksumBSD.FindStringSubmatch(line); match != nil {
+			name, hexDigest = match[2], match[3]
+		} else if match := reChecksumGNU.FindStringSubmatch(line); match != nil {
+			hexDigest, name = match[1], match[2]
+		} else {
+			return nil, fmt.Errorf("line %d: failed to parse checksum entry", lineNum)
+		}
+
+		name = strings.TrimPrefix(strings.TrimSpace(name), "./")
+		if name == "" {
+			name = defaultName
+		}
+		if name == "" {
+			return nil, fmt.Errorf("line %d: checksum entry has no file name", lineNum)
+		}
+
+		entry := ChecksumEntry{Name: name}
+		hexDigest = strings.ToLower(hexDigest)
+		if len(hexDigest) == 64 {
+			entry.SHA256 = hexDigest
+		} else {
+			entry.SHA512 = hexDigest
+		}
+		out = append(out, entry)
+	}
+	return out, s.Err()
+}
+
+// verifyChecksums checks every newly downloaded asset against the checksum
+// assets published in the same release.
+func (m *Mirror) verifyChecksums(ctx context.Context) error {
+	var firstErr error
+	for releaseIndex := range m.releases {
+		release := &m.releases[releaseIndex]
+		err := m.verifyReleaseChecksums(ctx, release)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (m *Mirror) verifyReleaseChecksums(ctx context.Context, release *indexfile.Release) error {
+	releaseDir := filepath.Join(m.OutputDir, release.Tag)
+	releaseLogger := zerolog.Ctx(ctx).With().
+		Int64("releaseID", release.ID).
+		Str("releaseTag", release.Tag).
+		Logger()
+
+	var firstErr error
+	pending := false
+	for _, asset := range release.Assets {
+		if asset.Type == indexfile.ChecksumType || asset.Derived() || asset.NotMirrored {
+			continue
+		}
+		switch asset.Checksum {
+		case indexfile.UnverifiedStatus:
+			pending = true
+		case indexfile.MismatchStatus:
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s/%s: digest does not match upstream checksum file", release.Tag, asset.Name)
+			}
+		}
+	}
+	if !pending {
+		return firstErr
+	}
+
+	entries := make(map[string][]ChecksumEntry, len(release.Assets))
+	for _, asset := range release.Assets {
+		if asset.Type != indexfile.ChecksumType || asset.NotMirrored {
+			continue
+		}
+
+		checksumPath := filepath.Join(releaseDir, asset.Name)
+		raw, err := os.ReadFile(checksumPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			releaseLogger.Error().
+				Str("path", checksumPath).
+				Err(err).
+				Msg("failed to read checksum file")
+			return err
+		}
+
+		defaultName := ""
+		if match := reChecksumName.FindStringSubmatch(asset.Name); match != nil {
+			defaultName = match[1]
+		}
+
+		list, err := ParseChecksumFile(raw, defaultName)
+		if err != nil {
+			releaseLogger.Warn().
+				Str("path", checksumPath).
+				Err(err).
+				Msg("failed to parse checksum file; ignoring it")
+			continue
+		}
+		for _, entry := range list {
+			entries[entry.Name] = append(entries[entry.Name], entry)
+		}
+	}
+
+	for assetIndex := range release.Assets {
+		asset := &release.Assets[assetIndex]
+		if asset.Type == indexfile.ChecksumType || asset.NotMirrored || asset.Checksum != indexfile.UnverifiedStatus {
+			continue
+		}
+		if asset.SHA256 == "" {
+			// Not downloaded yet.
+			continue
+		}
+		list := entries[asset.Name]
+		if len(list) == 0 {
+			continue
+		}
+
+		assetPath := filepath.Join(releaseDir, asset.Name)
+		assetLogger := releaseLogger.With().
+			Int64("assetID", asset.ID).
+			Str("assetPath", assetPath).
+			Logger()
+
+		ok, err := checkAssetChecksums(asset, assetPath, list)
+		if err != nil {
+			assetLogger.Error().
+				Err(err).
+				Msg("I/O error while computing digests of downloaded asset")
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if ok {
+			asset.Checksum = indexfile.VerifiedStatus
+			continue
+		}
+
+		asset.Checksum = indexfile.MismatchStatus
+		err = fmt.Errorf("%s/%s: digest does not match upstream checksum file", release.Tag, asset.Name)
+		if m.OnChecksumMismatch != QuarantineOnMismatch {
+			assetLogger.Error().
+				Msg("downloaded asset does not match upstream checksum file")
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		assetLogger.Warn().
+			Msg("downloaded asset does not match upstream checksum file; moving it to quarantine")
+		ctx2 := assetLogger.WithContext(ctx)
+		err = m.quarantine(ctx2, release, asset)
+		if err == nil {
+			asset.Checksum = indexfile.QuarantinedStatus
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func checkAssetChecksums(asset *indexfile.Asset, assetPath string, list []ChecksumEntry) (bool, error) {
+	for _, entry := range list {
+		if entry.SHA512 != "" && asset.SHA512 == "" {
+			digest, err := indexutil.DigestFile(assetPath, true)
+			if err != nil {
+				return false, err
+			}
+			setDigests(asset, digest)
+			break
+		}
+	}
+
+	for _, entry := range list {
+		if entry.SHA256 != "" && entry.SHA256 != asset.SHA256 {
+			return false, nil
+		}
+		if entry.SHA512 != "" && entry.SHA512 != asset.SHA512 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// quarantine moves an asset that failed verification out of the mirror.
+func (m *Mirror) quarantine(ctx context.Context, release *indexfile.Release, asset *indexfile.Asset) error {
+	assetPath := filepath.Join(m.OutputDir, release.Tag, asset.Name)
+	quarantinePath := filepath.Join(m.OutputDir, QuarantineDirName, release.Tag, asset.Name)
+	return indexutil.RenameFile(ctx, assetPath, quarantinePath, asset.Mode())
+}
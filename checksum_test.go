@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseChecksumFile(t *testing.T) {
+	const sha256a = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	const sha256b = "60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752"
+	const sha512a = "ee26b0dd4af7e749aa1a8ee3c10ae9923f618980772e473f8819a5d4940e0db27ac185f8a0e1d5f84f88bc887fd67b143732c304cc5fa9ad8e6f57f50028a8ff"
+
+	type testRow struct {
+		Name        string
+		Input       string
+		DefaultName string
+		Expected    []ChecksumEntry
+	}
+
+	testData := [...]testRow{
+		{
+			Name:  "gnu",
+			Input: sha256a + "  foo.tar.gz\n" + sha256b + "  bar.zip\n",
+			Expected: []ChecksumEntry{
+				{Name: "foo.tar.gz", SHA256: sha256a},
+				{Name: "bar.zip", SHA256: sha256b},
+			},
+		},
+		{
+			Name:     "gnu-binary-marker",
+			Input:    sha256a + " *foo.tar.gz\n",
+			Expected: []ChecksumEntry{{Name: "foo.tar.gz", SHA256: sha256a}},
+		},
+		{
+			Name:     "gnu-tab",
+			Input:    sha256a + "\tfoo.tar.gz\n",
+			Expected: []ChecksumEntry{{Name: "foo.tar.gz", SHA256: sha256a}},
+		},
+		{
+			Name:     "gnu-dot-slash",
+			Input:    sha256a + "  ./foo.tar.gz\n",
+			Expected: []ChecksumEntry{{Name: "foo.tar.gz", SHA256: sha256a}},
+		},
+		{
+			Name:     "gnu-sha512",
+			Input:    sha512a + "  foo.tar.gz\n",
+			Expected: []ChecksumEntry{{Name: "foo.tar.gz", SHA512: sha512a}},
+		},
+		{
+			Name:  "bsd",
+			Input: "SHA256 (foo.tar.gz) = " + sha256a + "\nSHA512 (bar (1).zip) = " + sha512a + "\n",
+			Expected: []ChecksumEntry{
+				{Name: "foo.tar.gz", SHA256: sha256a},
+				{Name: "bar (1).zip", SHA512: sha512a},
+			},
+		},
+		{
+			Name:     "bsd-compact",
+			Input:    "SHA256(foo.tar.gz)=" + sha256a + "\n",
+			Expected: []ChecksumEntry{{Name: "foo.tar.gz", SHA256: sha256a}},
+		},
+		{
+			Name:  "crlf",
+			Input: sha256a + "  foo.tar.gz\r\nSHA256 (bar.zip) = " + sha256b + "\r\n",
+			Expected: []ChecksumEntry{
+				{Name: "foo.tar.gz", SHA256: sha256a},
+				{Name: "bar.zip", SHA256: sha256b},
+			},
+		},
+		{
+			Name:     "uppercase-hex",
+			Input:    strings.ToUpper(sha256a) + "  foo.tar.gz\n",
+			Expected: []ChecksumEntry{{Name: "foo.tar.gz", SHA256: sha256a}},
+		},
+		{
+			Name:     "comments-and-blank-lines",
+			Input:    "# generated by CI\n\n" + sha256a + "  foo.tar.gz\n\n",
+			Expected: []ChecksumEntry{{Name: "foo.tar.gz", SHA256: sha256a}},
+		},
+		{
+			Name:        "bare-digest",
+			Input:       sha256a + "\n",
+			DefaultName: "foo.tar.gz",
+			Expected:    []ChecksumEntry{{Name: "foo.tar.gz", SHA256: sha256a}},
+		},
+		{
+			// Every entry is kept, so that a second, conflicting entry
+			// for the same file fails verification rather than
+			// silently replacing the first.
+			Name:  "duplicate-names",
+			Input: sha256a + "  foo.tar.gz\n" + sha256b + "  foo.tar.gz\n" + sha512a + "  foo.tar.gz\n",
+			Expected: []ChecksumEntry{
+				{Name: "foo.tar.gz", SHA256: sha256a},
+				{Name: "foo.tar.gz", SHA256: sha256b},
+				{Name: "foo.tar.gz", SHA512: sha512a},
+			},
+		},
+	}
+
+	for _, row := range testData {
+		t.Run(row.Name, func(t *testing.T) {
+			actual, err := ParseChecksumFile([]byte(row.Input), row.DefaultName)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(actual) != len(row.Expected) {
+				t.Fatalf("expected %d entries, got %d: %+v", len(row.Expected), len(actual), actual)
+			}
+			for index := range actual {
+				if actual[index] != row.Expected[index] {
+					t.Errorf("entry %d: expected %+v, got %+v", index, row.Expected[index], actual[index])
+				}
+			}
+		})
+	}
+}
+
+func TestParseChecksumFile_Invalid(t *testing.T) {
+	const sha256a = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+	type testRow struct {
+		Name  string
+		Input string
+	}
+
+	testData := [...]testRow{
+		{"too-short", sha256a[:63] + "  foo.tar.gz\n"},
+		{"too-long", sha256a + "0  foo.tar.gz\n"},
+		{"sha384-length", sha256a + sha256a[:32] + "  foo.tar.gz\n"},
+		{"sha1-length", sha256a[:40] + "  foo.tar.gz\n"},
+		{"not-hex", strings.Replace(sha256a, "9", "g", 1) + "  foo.tar.gz\n"},
+		{"bsd-wrong-length", "SHA256 (foo.tar.gz) = " + sha256a[:60] + "\n"},
+		{"bsd-unknown-algorithm", "MD5 (foo.tar.gz) = " + sha256a + "\n"},
+		{"bare-digest-without-default", sha256a + "\n"},
+		{"garbage", "this is not a checksum file\n"},
+	}
+
+	for _, row := range testData {
+		t.Run(row.Name, func(t *testing.T) {
+			actual, err := ParseChecksumFile([]byte(row.Input), "")
+			if err == nil {
+				t.Errorf("expected an error, got %+v", actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog"
+
+	"github.com/chronos-tachyon/github-asset-mirror/indexfile"
+	"github.com/chronos-tachyon/github-asset-mirror/indexutil"
+)
+
+type Config struct {
+	OutputDir          string                `yaml:"outputDir,omitempty"`
+	GitHubURL          string                `yaml:"githubURL,omitempty"`
+	UploadURL          string                `yaml:"uploadURL,omitempty"`
+	CABundle           string                `yaml:"caBundle,omitempty"`
+	TokenFile          string                `yaml:"tokenFile,omitempty"`
+	App                *AppConfig            `yaml:"app,omitempty"`
+	TokenCommand       []string              `yaml:"tokenCommand,omitempty"`
+	Anonymous          bool                  `yaml:"anonymous,omitempty"`
+	Hosts              map[string]HostConfig `yaml:"hosts,omitempty"`
+	Jobs               int                   `yaml:"jobs,omitempty"`
+	Retry              RetryPolicy           `yaml:"retry,omitempty"`
+	RateLimit          RateLimitPolicy       `yaml:"rateLimit,omitempty"`
+	SHA512             bool                  `yaml:"sha512,omitempty"`
+	ExtractArchives    bool                  `yaml:"extractArchives,omitempty"`
+	OnChecksumMismatch string                `yaml:"onChecksumMismatch,omitempty"`
+	Naming             []NamingRuleConfig    `yaml:"naming,omitempty"`
+	Retention          RetentionPolicy       `yaml:"retention,omitempty"`
+	Repos              []RepoConfig          `yaml:"repos"`
+}
+
+type RepoConfig struct {
+	Owner               string             `yaml:"owner"`
+	Repo                string             `yaml:"repo"`
+	GitHubURL           string             `yaml:"githubURL,omitempty"`
+	OutputDir           string             `yaml:"outputDir,omitempty"`
+	TokenFile           string             `yaml:"tokenFile,omitempty"`
+	Filters             ReleaseFilters     `yaml:"filters,omitempty"`
+	ProvenancePublicKey string             `yaml:"provenancePublicKey,omitempty"`
+	Naming              []NamingRuleConfig `yaml:"naming,omitempty"`
+	Retention           *RetentionPolicy   `yaml:"retention,omitempty"`
+}
+
+func LoadConfig(ctx context.Context, configFile string) (Config, error) {
+	logger := zerolog.Ctx(ctx).With().
+		Str("configFile", configFile).
+		Logger()
+
+	var cfg Config
+
+	raw, err := os.ReadFile(configFile)
+	if err != nil {
+		logger.Error().
+			Err(err).
+			Msg("failed to read contents of YAML config file")
+		return cfg, err
+	}
+
+	ctx = logger.WithContext(ctx)
+	err = indexutil.FromYAML(ctx, &cfg, raw)
+	return cfg, err
+}
+
+func (cfg *Config) Validate() error {
+	if len(cfg.Repos) == 0 {
+		return errors.New("no repositories to mirror")
+	}
+	if cfg.Jobs < 0 {
+		return fmt.Errorf("jobs: must not be negative, got %d", cfg.Jobs)
+	}
+	if cfg.GitHubURL != "" {
+		if err := validateURL("githubURL", cfg.GitHubURL); err != nil {
+			return err
+		}
+	}
+	if err := cfg.defaultHost().Validate(); err != nil {
+		return err
+	}
+	for name, host := range cfg.Hosts {
+		if err := host.Validate(); err != nil {
+			return fmt.Errorf("hosts[%q]: %w", name, err)
+		}
+	}
+	if err := cfg.Retry.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.RateLimit.Validate(); err != nil {
+		return err
+	}
+	switch cfg.OnChecksumMismatch {
+	case "", FailOnMismatch, QuarantineOnMismatch:
+		// pass
+	default:
+		return fmt.Errorf("onChecksumMismatch: must be %q or %q, got %q", FailOnMismatch, QuarantineOnMismatch, cfg.OnChecksumMismatch)
+	}
+	if _, err := CompileNamingRules(cfg.Naming); err != nil {
+		return err
+	}
+	if err := cfg.Retention.Validate(); err != nil {
+		return err
+	}
+
+	seen := make(map[string]string, len(cfg.Repos))
+	for index := range cfg.Repos {
+		repo := &cfg.Repos[index]
+		if repo.Owner == "" {
+			return fmt.Errorf("repos[%d]: missing required field \"owner\"", index)
+		}
+		if repo.Repo == "" {
+			return fmt.Errorf("repos[%d]: missing required field \"repo\"", index)
+		}
+		if repo.GitHubURL != "" {
+			if err := validateURL("githubURL", repo.GitHubURL); err != nil {
+				return fmt.Errorf("repos[%d]: %s: %w", index, repo.FullName(), err)
+			}
+		}
+		outputDir := cfg.RepoOutputDir(repo)
+		if outputDir == "" {
+			return fmt.Errorf("repos[%d]: %s: no output directory configured", index, repo.FullName())
+		}
+		if other, found := seen[outputDir]; found {
+			return fmt.Errorf("repos[%d]: %s: output directory %q is already used by %s", index, repo.FullName(), outputDir, other)
+		}
+		seen[outputDir] = repo.FullName()
+
+		err := repo.Filters.Validate()
+		if err != nil {
+			return fmt.Errorf("repos[%d]: %s: %w", index, repo.FullName(), err)
+		}
+
+		_, err = CompileNamingRules(repo.Naming)
+		if err != nil {
+			return fmt.Errorf("repos[%d]: %s: %w", index, repo.FullName(), err)
+		}
+
+		if repo.Retention != nil {
+			err = repo.Retention.Validate()
+			if err != nil {
+				return fmt.Errorf("repos[%d]: %s: %w", index, repo.FullName(), err)
+			}
+		}
+	}
+	return nil
+}
+
+func (cfg *Config) RepoOutputDir(repo *RepoConfig) string {
+	dir := repo.OutputDir
+	if dir == "" {
+		dir = filepath.Join(repo.Owner, repo.Repo)
+	}
+	if filepath.IsAbs(dir) {
+		return filepath.Clean(dir)
+	}
+	if cfg.OutputDir == "" {
+		return ""
+	}
+	return filepath.Join(cfg.OutputDir, dir)
+}
+
+// RepoNaming returns the asset naming rules for repo.  Rules configured on
+// the repo replace, rather than extend, the top-level rules.
+func (cfg *Config) RepoNaming(repo *RepoConfig) (indexfile.NamingRules, error) {
+	if len(repo.Naming) != 0 {
+		return CompileNamingRules(repo.Naming)
+	}
+	return CompileNamingRules(cfg.Naming)
+}
+
+// RepoRetention returns the retention policy for repo.  A policy configured
+// on the repo replaces the top-level policy as a whole.
+func (cfg *Config) RepoRetention(repo *RepoConfig) RetentionPolicy {
+	if repo.Retention != nil {
+		return *repo.Retention
+	}
+	return cfg.Retention
+}
+
+// RepoGitHubURL returns the API base URL of the GitHub instance hosting
+// repo.
+func (cfg *Config) RepoGitHubURL(repo *RepoConfig) string {
+	if repo.GitHubURL != "" {
+		return repo.GitHubURL
+	}
+	if cfg.GitHubURL != "" {
+		return cfg.GitHubURL
+	}
+	return DefaultGitHubURL
+}
+
+// RepoHost returns the settings for the GitHub instance hosting repo.  The
+// top-level credentials, uploadURL and caBundle apply only to the top-level
+// githubURL, so that a token is never sent to a host it was not meant for.
+func (cfg *Config) RepoHost(repo *RepoConfig) HostConfig {
+	hostName := HostName(cfg.RepoGitHubURL(repo))
+	host := cfg.Hosts[hostName]
+	if hostName == HostName(cfg.RepoGitHubURL(&RepoConfig{})) {
+		if !host.HasCredentials() {
+			host.TokenFile = cfg.TokenFile
+			host.App = cfg.App
+			host.TokenCommand = cfg.TokenCommand
+			host.Anonymous = cfg.Anonymous
+		}
+		if host.UploadURL == "" {
+			host.UploadURL = cfg.UploadURL
+		}
+		if host.CABundle == "" {
+			host.CABundle = cfg.CABundle
+		}
+	}
+	return host
+}
+
+// defaultHost returns the top-level settings, which apply to the top-level
+// githubURL.
+func (cfg *Config) defaultHost() HostConfig {
+	return HostConfig{
+		TokenFile:    cfg.TokenFile,
+		App:          cfg.App,
+		TokenCommand: cfg.TokenCommand,
+		Anonymous:    cfg.Anonymous,
+		UploadURL:    cfg.UploadURL,
+		CABundle:     cfg.CABundle,
+	}
+}
+
+func (repo *RepoConfig) FullName() string {
+	return repo.Owner + "/" + repo.Repo
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/go-github/v48/github"
+	"github.com/rs/zerolog"
+
+	"github.com/chronos-tachyon/github-asset-mirror/indexfile"
+	"github.com/chronos-tachyon/github-asset-mirror/indexutil"
+)
+
+type downloadJob struct {
+	Release *indexfile.Release
+	Asset   *indexfile.Asset
+}
+
+func (m *Mirror) downloadAssets(ctx context.Context) error {
+	jobs := make([]downloadJob, 0, 16*len(m.releases))
+	for releaseIndex := range m.releases {
+		release := &m.releases[releaseIndex]
+		if release.Withdrawn {
+			// Gone upstream; whatever is still on disk is all there is.
+			continue
+		}
+		for assetIndex := range release.Assets {
+			asset := &release.Assets[assetIndex]
+			if asset.Derived() || asset.NotMirrored {
+				continue
+			}
+			jobs = append(jobs, downloadJob{Release: release, Asset: asset})
+		}
+	}
+
+	numWorkers := m.Jobs
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	if numWorkers > len(jobs) {
+		numWorkers = len(jobs)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+
+	ch := make(chan downloadJob)
+	wg.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			defer wg.Done()
+			for job := range ch {
+				err := m.downloadAsset(ctx, job.Release, job.Asset)
+				if err == nil {
+					continue
+				}
+				errOnce.Do(func() {
+					firstErr = err
+				})
+				// One failed asset does not stop the others, but
+				// once the rate limit budget is spent every further
+				// download from the API would overdraw it.
+				if errors.Is(err, ErrRateLimitBudget) {
+					cancel()
+				}
+			}
+		}()
+	}
+
+Loop:
+	for _, job := range jobs {
+		select {
+		case ch <- job:
+		case <-ctx.Done():
+			break Loop
+		}
+	}
+	close(ch)
+	wg.Wait()
+	m.removeEmptyStagingDirs()
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return firstErr
+}
+
+func (m *Mirror) downloadAsset(ctx context.Context, release *indexfile.Release, asset *indexfile.Asset) error {
+	assetPath := filepath.Join(m.OutputDir, release.Tag, asset.Name)
+
+	assetLogger := zerolog.Ctx(ctx).With().
+		Int64("releaseID", release.ID).
+		Str("releaseTag", release.Tag).
+		Int64("assetID", asset.ID).
+		Str("assetURL", asset.URL).
+		Str("assetPath", assetPath).
+		Logger()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// A file being fetched again stays in place, digests and all, until
+	// its replacement has been staged in full.
+	_, err := os.Stat(assetPath)
+	if err == nil && !m.refetch[assetPath] {
+		return m.backfillDigests(assetLogger.WithContext(ctx), assetPath, asset)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		assetLogger.Error().
+			Err(err).
+			Msg("failed to stat file containing downloaded asset")
+		return err
+	}
+
+	staged := m.stagedFileFor(release.Tag, asset.Name, m.downloadURL(asset), asset.Mode())
+
+	// Fetching from the API counts against the rate limit like any other
+	// call.  If the budget runs low, the asset was still downloaded in full
+	// and is kept before stopping.
+	ctx2 := assetLogger.WithContext(ctx)
+	err = m.callGitHub(ctx2, "download asset", func() (*github.Response, error) {
+		return m.fetchAsset(ctx2, staged)
+	})
+	budgetErr := err
+	if err != nil && !errors.Is(err, ErrRateLimitBudget) {
+		return err
+	}
+
+	err = staged.Commit(ctx2, assetPath)
+	if err != nil {
+		return err
+	}
+
+	setDigests(asset, staged.Digest)
+	asset.ResetLocalState()
+	return budgetErr
+}
+
+// removeEmptyStagingDirs removes the per-release staging directories, and
+// the staging area itself, once every download staged there has been
+// committed.  Removing a directory that is not empty fails harmlessly.
+func (m *Mirror) removeEmptyStagingDirs() {
+	stagingDir := filepath.Join(m.OutputDir, StagingDirName)
+	for _, release := range m.releases {
+		_ = os.Remove(filepath.Join(stagingDir, release.Tag))
+	}
+	_ = os.Remove(stagingDir)
+}
+
+// backfillDigests computes the digests of an asset that was downloaded by an
+// earlier run which did not record them.
+func (m *Mirror) backfillDigests(ctx context.Context, assetPath string, asset *indexfile.Asset) error {
+	if asset.SHA256 != "" && (asset.SHA512 != "" || !m.SHA512) {
+		return nil
+	}
+
+	logger := zerolog.Ctx(ctx)
+	logger.Info().
+		Msg("computing digests of previously downloaded asset")
+
+	digest, err := indexutil.DigestFile(assetPath, m.SHA512)
+	if err != nil {
+		logger.Error().
+			Err(err).
+			Msg("I/O error while computing digests of downloaded asset")
+		return err
+	}
+
+	setDigests(asset, digest)
+	return nil
+}
+
+func setDigests(asset *indexfile.Asset, digest *indexutil.Digester) {
+	asset.Size = digest.Size()
+	asset.SHA256 = digest.SHA256()
+	asset.SHA512 = digest.SHA512()
+}
+
+// fetchAsset downloads the asset into the staging area, continuing from
+// whatever data is already staged when possible.  It returns the last
+// response from the GitHub API, for its rate limit headers.
+func (m *Mirror) fetchAsset(ctx context.Context, staged *stagedFile) (*github.Response, error) {
+	assetLogger := zerolog.Ctx(ctx)
+
+	staged.Load(ctx)
+
+	if staged.Offset > 0 {
+		assetLogger.Info().
+			Int64("offset", staged.Offset).
+			Msg("resuming download of asset to local file")
+	} else {
+		assetLogger.Info().
+			Msg("downloading asset to local file")
+	}
+
+	ok, apiResp, err := m.fetchStaged(ctx, staged)
+	if err == nil && !ok {
+		assetLogger.Info().
+			Msg("staged data cannot be resumed; restarting download")
+		staged.Discard()
+		ok, apiResp, err = m.fetchStaged(ctx, staged)
+		if err == nil && !ok {
+			err = fmt.Errorf("GET %s: server refused to send the complete asset", staged.URL)
+		}
+	}
+	if err != nil {
+		return apiResp, err
+	}
+
+	if !staged.Complete() {
+		assetLogger.Warn().
+			Int64("bytesReceived", staged.Offset).
+			Int64("bytesExpected", staged.State.Size).
+			Msg("download ended before the entire asset was received")
+		return apiResp, fmt.Errorf("GET %s: received %d of %d bytes: %w", staged.URL, staged.Offset, staged.State.Size, io.ErrUnexpectedEOF)
+	}
+	return apiResp, nil
+}
+
+// downloadURL returns the URL to fetch the asset's contents from.  Uploaded
+// assets go through the REST API rather than the browser download URL,
+// because only the former works for private repositories.
+func (m *Mirror) downloadURL(asset *indexfile.Asset) string {
+	if asset.ID == 0 {
+		return asset.URL
+	}
+	u := *m.Client.BaseURL
+	u.Path += fmt.Sprintf("repos/%s/%s/releases/assets/%d", url.PathEscape(m.Owner), url.PathEscape(m.Repo), asset.ID)
+	return u.String()
+}
+
+// get requests the staged file's URL with the authenticated client.  If the
+// API redirects to a storage host, the redirect is followed with the
+// unauthenticated client, so that the GitHub token is never sent there.  It
+// returns the final response and the API's own response; if the API's
+// response shows that a rate limit was hit, it returns the rate limit error
+// instead, so that the request can be tried again once the limit resets.
+func (m *Mirror) get(ctx context.Context, staged *stagedFile) (*http.Response, *github.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, staged.URL, http.NoBody)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("accept", "application/octet-stream")
+	staged.PrepareRequest(req)
+
+	apiClient := *m.HTTP
+	apiClient.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	apiResp := ResponseRate(resp)
+
+	if err := CheckRateLimit(resp); err != nil {
+		_ = resp.Body.Close()
+		return nil, apiResp, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		// pass
+	default:
+		return resp, apiResp, nil
+	}
+
+	location, err := resp.Location()
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, apiResp, fmt.Errorf("GET %s: redirect without a valid Location: %w", staged.URL, err)
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, location.String(), http.NoBody)
+	if err != nil {
+		return nil, apiResp, err
+	}
+	staged.PrepareRequest(req)
+	resp, err = m.Storage.Do(req)
+	return resp, apiResp, err
+}
+
+// fetchStaged makes a single request for the asset, continuing from whatever
+// data is already staged.  It returns false if the server's response could
+// not be used to continue the staged data.
+func (m *Mirror) fetchStaged(ctx context.Context, staged *stagedFile) (bool, *github.Response, error) {
+	assetLogger := zerolog.Ctx(ctx)
+
+	resp, apiResp, err := m.get(ctx, staged)
+	if err != nil {
+		return false, apiResp, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	statusLogger := assetLogger.With().
+		Int("statusCode", resp.StatusCode).
+		Logger()
+
+	ok, err := staged.AcceptResponse(resp)
+	if err != nil {
+		return false, apiResp, err
+	}
+	if !ok || resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		return ok, apiResp, nil
+	}
+
+	ctx2 := statusLogger.WithContext(ctx)
+	err = staged.SaveState(ctx2)
+	if err != nil {
+		return false, apiResp, err
+	}
+
+	err = staged.Append(ctx2, resp.Body)
+	return err == nil, apiResp, err
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v48/github"
+
+	"github.com/chronos-tachyon/github-asset-mirror/indexfile"
+)
+
+func TestDownloadAsset_RateLimit(t *testing.T) {
+	const assetData = "asset contents\n"
+
+	type testRow struct {
+		Name          string
+		Limited       func(w http.ResponseWriter)
+		Remaining     int
+		Policy        RateLimitPolicy
+		CheckErr      func(error) bool
+		ExpectedCalls int32
+		ExpectedFile  bool
+	}
+
+	primary := func(w http.ResponseWriter) {
+		w.Header().Set("x-ratelimit-limit", "5000")
+		w.Header().Set("x-ratelimit-remaining", "0")
+		w.Header().Set("x-ratelimit-reset", strconv.FormatInt(time.Now().Unix(), 10))
+		http.Error(w, `{"message":"API rate limit exceeded"}`, http.StatusForbidden)
+	}
+	secondary := func(w http.ResponseWriter) {
+		w.Header().Set("retry-after", "1")
+		http.Error(w, `{"message":"slow down"}`, http.StatusTooManyRequests)
+	}
+	farReset := func(w http.ResponseWriter) {
+		w.Header().Set("x-ratelimit-limit", "5000")
+		w.Header().Set("x-ratelimit-remaining", "0")
+		w.Header().Set("x-ratelimit-reset", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10))
+		http.Error(w, `{"message":"API rate limit exceeded"}`, http.StatusForbidden)
+	}
+
+	isNil := func(err error) bool {
+		return err == nil
+	}
+	isRateLimit := func(err error) bool {
+		var rateErr *github.RateLimitError
+		return errors.As(err, &rateErr)
+	}
+	isBudget := func(err error) bool {
+		return errors.Is(err, ErrRateLimitBudget)
+	}
+
+	testData := [...]testRow{
+		{"primary-403", primary, 4000, RateLimitPolicy{MaxWait: time.Minute}, isNil, 2, true},
+		{"secondary-429", secondary, 4000, RateLimitPolicy{MaxWait: time.Minute}, isNil, 2, true},
+		{"reset-beyond-maxWait", farReset, 4000, RateLimitPolicy{MaxWait: time.Minute}, isRateLimit, 1, false},
+		{"budget-exhausted", nil, 5, RateLimitPolicy{MinRemaining: 10, MaxWait: time.Minute}, isBudget, 1, true},
+	}
+
+	for _, row := range testData {
+		t.Run(row.Name, func(t *testing.T) {
+			var numCalls int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/api/v3/repos/owner/repo/releases/assets/42":
+					if atomic.AddInt32(&numCalls, 1) == 1 && row.Limited != nil {
+						row.Limited(w)
+						return
+					}
+					w.Header().Set("x-ratelimit-limit", "5000")
+					w.Header().Set("x-ratelimit-remaining", strconv.Itoa(row.Remaining))
+					http.Redirect(w, r, "/storage/tool.txt", http.StatusFound)
+				case "/storage/tool.txt":
+					_, _ = w.Write([]byte(assetData))
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer ts.Close()
+
+			ctx := context.Background()
+			outputDir := t.TempDir()
+			cfg := &Config{
+				OutputDir: outputDir,
+				GitHubURL: ts.URL,
+				Anonymous: true,
+				Retry:     RetryPolicy{MaxAttempts: 1},
+				RateLimit: row.Policy,
+			}
+			repo := &RepoConfig{Owner: "owner", Repo: "repo", OutputDir: "."}
+			m, err := NewMirror(ctx, cfg, repo)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			release := &indexfile.Release{ID: 1, Tag: "v1.0.0"}
+			asset := &indexfile.Asset{ID: 42, Name: "tool.txt", URL: ts.URL + "/download/tool.txt"}
+			err = m.downloadAsset(ctx, release, asset)
+
+			if !row.CheckErr(err) {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if actual := atomic.LoadInt32(&numCalls); actual != row.ExpectedCalls {
+				t.Errorf("expected %d API calls, got %d", row.ExpectedCalls, actual)
+			}
+
+			raw, err := os.ReadFile(filepath.Join(outputDir, "v1.0.0", "tool.txt"))
+			switch {
+			case row.ExpectedFile && err != nil:
+				t.Errorf("expected asset to be downloaded, got %v", err)
+			case row.ExpectedFile && string(raw) != assetData:
+				t.Errorf("expected %q, got %q", assetData, raw)
+			case !row.ExpectedFile && err == nil:
+				t.Errorf("expected asset not to be downloaded")
+			}
+		})
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"github.com/chronos-tachyon/github-asset-mirror/indexfile"
+	"github.com/chronos-tachyon/github-asset-mirror/indexutil"
+)
+
+// ExtractDirName is the directory beneath each release directory where the
+// contents of archive assets are unpacked, one subdirectory per archive.
+const ExtractDirName = "extracted"
+
+// Upstream archives are untrusted, so extraction stops once an archive
+// holds more than MaxExtractedFiles regular files or MaxExtractedBytes of
+// uncompressed data.
+const (
+	MaxExtractedFiles = 10000
+	MaxExtractedBytes = 4 << 30
+)
+
+// errExtractLimit is returned when an archive exceeds the extraction limits.
+var errExtractLimit = errors.New("archive exceeds extraction limits")
+
+// archiveMember is a regular file read from an archive.
+type archiveMember struct {
+	Name       string
+	Executable bool
+	Open       func() (io.ReadCloser, error)
+}
+
+// extractArchives unpacks every archive asset that did not fail verification
+// and has not been unpacked yet, recording its contents as derived assets.
+func (m *Mirror) extractArchives(ctx context.Context) error {
+	if !m.ExtractArchives {
+		return nil
+	}
+
+	var firstErr error
+	for releaseIndex := range m.releases {
+		release := &m.releases[releaseIndex]
+		err := m.extractReleaseArchives(ctx, release)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (m *Mirror) extractReleaseArchives(ctx context.Context, release *indexfile.Release) error {
+	releaseDir := filepath.Join(m.OutputDir, release.Tag)
+	releaseLogger := zerolog.Ctx(ctx).With().
+		Int64("releaseID", release.ID).
+		Str("releaseTag", release.Tag).
+		Logger()
+
+	var firstErr error
+	var archives []indexfile.Asset
+	for _, asset := range release.Assets {
+		if asset.Type != indexfile.ArchiveType || asset.Derived() || asset.NotMirrored || asset.Quarantined() {
+			continue
+		}
+		if asset.SHA256 == "" {
+			// Still to be downloaded.
+			continue
+		}
+		if asset.Checksum == indexfile.MismatchStatus || asset.Provenance == indexfile.MismatchStatus {
+			continue
+		}
+		if archiveWalker(asset.Name) == nil {
+			releaseLogger.Info().
+				Int64("assetID", asset.ID).
+				Str("assetName", asset.Name).
+				Msg("not extracting archive asset in unsupported format")
+			continue
+		}
+		if m.isExtracted(releaseDir, release, asset) {
+			continue
+		}
+		archives = append(archives, asset)
+	}
+	if len(archives) == 0 {
+		return nil
+	}
+
+	for _, archive := range archives {
+		archivePath := filepath.Join(releaseDir, archive.Name)
+		archiveLogger := releaseLogger.With().
+			Int64("assetID", archive.ID).
+			Str("assetPath", archivePath).
+			Logger()
+		ctx2 := archiveLogger.WithContext(ctx)
+
+		derived, err := m.extractArchive(ctx2, releaseDir, archive)
+		if err != nil {
+			// The extraction directory is gone, and with it any files
+			// from an earlier extraction.
+			release.Assets = withoutDerivedFrom(release.Assets, archive.Name)
+		}
+		if errors.Is(err, errExtractLimit) {
+			archiveLogger.Warn().
+				Err(err).
+				Msg("not extracting archive asset that is too large")
+			continue
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		release.Assets = append(withoutDerivedFrom(release.Assets, archive.Name), derived...)
+
+		archiveLogger.Info().
+			Int("numFiles", len(derived)).
+			Msg("extracted archive asset")
+	}
+
+	type AssetList = indexfile.SortableList[indexfile.Asset]
+	AssetList(release.Assets).Sort()
+	return firstErr
+}
+
+// withoutDerivedFrom removes the files extracted from the named archive from
+// assets, in place.
+func withoutDerivedFrom(assets []indexfile.Asset, archiveName string) []indexfile.Asset {
+	out := assets[:0]
+	for _, asset := range assets {
+		if asset.Parent != archiveName {
+			out = append(out, asset)
+		}
+	}
+	return out
+}
+
+// isExtracted reports whether archive has been unpacked already, from the
+// same archive contents as are on disk now, and all of the files it
+// contained are still present.
+func (m *Mirror) isExtracted(releaseDir string, release *indexfile.Release, archive indexfile.Asset) bool {
+	found := false
+	for _, asset := range release.Assets {
+		if asset.Parent != archive.Name {
+			continue
+		}
+		found = true
+		if asset.ParentSHA256 != archive.SHA256 {
+			return false
+		}
+		_, err := os.Stat(filepath.Join(releaseDir, asset.Name))
+		if err != nil {
+			return false
+		}
+	}
+	return found
+}
+
+// extractArchive unpacks a single archive into its own directory beneath
+// ExtractDirName, replacing anything left there by an earlier run.
+func (m *Mirror) extractArchive(ctx context.Context, releaseDir string, archive indexfile.Asset) ([]indexfile.Asset, error) {
+	logger := zerolog.Ctx(ctx)
+
+	archivePath := filepath.Join(releaseDir, archive.Name)
+	extractDir := filepath.Join(releaseDir, ExtractDirName, archive.Name)
+
+	err := os.RemoveAll(extractDir)
+	if err != nil {
+		logger.Error().
+			Str("path", extractDir).
+			Err(err).
+			Msg("failed to remove previously extracted files")
+		return nil, err
+	}
+
+	var derived []indexfile.Asset
+	var totalBytes int64
+	walk := archiveWalker(archive.Name)
+	err = walk(archivePath, func(member archiveMember) error {
+		memberPath, ok := safeMemberPath(member.Name)
+		if !ok {
+			logger.Warn().
+				Str("member", member.Name).
+				Msg("skipping archive member with unsafe path")
+			return nil
+		}
+		if len(derived) >= MaxExtractedFiles {
+			return fmt.Errorf("%w: more than %d files", errExtractLimit, MaxExtractedFiles)
+		}
+
+		asset := m.Naming.MakeDerivedAsset(archive, path.Join(ExtractDirName, archive.Name, memberPath), member.Executable)
+		assetPath := filepath.Join(releaseDir, asset.Name)
+
+		r, err := member.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		digest := indexutil.NewDigester(m.SHA512)
+		ctx2 := logger.With().Str("assetPath", assetPath).Logger().WithContext(ctx)
+		remaining := int64(MaxExtractedBytes) - totalBytes
+		n, err := indexutil.WriteFileFrom(ctx2, assetPath, io.TeeReader(io.LimitReader(r, remaining+1), digest), asset.Mode())
+		if err != nil {
+			return err
+		}
+		if n > remaining {
+			return fmt.Errorf("%w: more than %d bytes", errExtractLimit, int64(MaxExtractedBytes))
+		}
+		totalBytes += n
+
+		setDigests(&asset, digest)
+		derived = append(derived, asset)
+		return nil
+	})
+	if err != nil {
+		_ = os.RemoveAll(extractDir)
+		if !errors.Is(err, errExtractLimit) {
+			logger.Error().
+				Err(err).
+				Msg("failed to extract archive asset")
+		}
+		return nil, err
+	}
+	return derived, nil
+}
+
+// archiveFormats lists the archive formats that can be unpacked, keyed by
+// file name suffix.
+var archiveFormats = []struct {
+	Suffix string
+	Walk   func(archivePath string, fn func(archiveMember) error) error
+}{
+	{".zip", walkZip},
+	{".tar.gz", walkTarGzip},
+	{".tgz", walkTarGzip},
+	{".tar.bz2", walkTarBzip2},
+	{".tbz2", walkTarBzip2},
+	{".tar", walkTarPlain},
+}
+
+// archiveWalker returns the function that walks archives of the same
+// format as assetName, or nil if the format is not supported.  The walker
+// calls fn for each regular file in the archive; directories, symlinks, hard
+// links and device nodes are skipped.
+func archiveWalker(assetName string) func(string, func(archiveMember) error) error {
+	lower := strings.ToLower(assetName)
+	for _, row := range archiveFormats {
+		if strings.HasSuffix(lower, row.Suffix) {
+			return row.Walk
+		}
+	}
+	return nil
+}
+
+func walkZip(archivePath string, fn func(archiveMember) error) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, file := range zr.File {
+		mode := file.Mode()
+		if !mode.IsRegular() {
+			continue
+		}
+		file := file
+		err = fn(archiveMember{
+			Name:       file.Name,
+			Executable: mode&0o111 != 0,
+			Open:       file.Open,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTarGzip(archivePath string, fn func(archiveMember) error) error {
+	return walkTar(archivePath, func(r io.Reader) (io.Reader, error) {
+		return gzip.NewReader(r)
+	}, fn)
+}
+
+func walkTarBzip2(archivePath string, fn func(archiveMember) error) error {
+	return walkTar(archivePath, func(r io.Reader) (io.Reader, error) {
+		return bzip2.NewReader(r), nil
+	}, fn)
+}
+
+func walkTarPlain(archivePath string, fn func(archiveMember) error) error {
+	return walkTar(archivePath, func(r io.Reader) (io.Reader, error) {
+		return r, nil
+	}, fn)
+}
+
+func walkTar(archivePath string, decompress func(io.Reader) (io.Reader, error), fn func(archiveMember) error) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := decompress(f)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		err = fn(archiveMember{
+			Name:       hdr.Name,
+			Executable: fs.FileMode(hdr.Mode)&0o111 != 0,
+			Open: func() (io.ReadCloser, error) {
+				return io.NopCloser(tr), nil
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// safeMemberPath cleans the path of an archive member, rejecting any path
+// that would escape the extraction directory.  Windows drive letters are
+// rejected on every OS, so that a mirror behaves the same wherever it runs.
+func safeMemberPath(name string) (string, bool) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || filepath.VolumeName(filepath.FromSlash(name)) != "" || hasDriveLetter(name) {
+		return "", false
+	}
+	name = path.Clean(name)
+	if name == "." || name == ".." || strings.HasPrefix(name, "../") {
+		return "", false
+	}
+	return name, true
+}
+
+func hasDriveLetter(name string) bool {
+	if len(name) < 2 || name[1] != ':' {
+		return false
+	}
+	ch := name[0]
+	return (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z')
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestSafeMemberPath(t *testing.T) {
+	type testRow struct {
+		Input    string
+		Expected string
+		OK       bool
+	}
+
+	testData := [...]testRow{
+		{"bin/tool", "bin/tool", true},
+		{"tool", "tool", true},
+		{"./tool", "tool", true},
+		{"a/b/../c", "a/c", true},
+		{"a//b/", "a/b", true},
+		{"a\\b\\c", "a/b/c", true},
+		{"../x", "", false},
+		{"a/../../x", "", false},
+		{"..", "", false},
+		{".", "", false},
+		{"", "", false},
+		{"/etc/x", "", false},
+		{"..\\x", "", false},
+		{"a\\..\\..\\x", "", false},
+		{"\\etc\\x", "", false},
+		{"C:/x", "", false},
+		{"c:\\x", "", false},
+		{"C:x", "", false},
+	}
+
+	for _, row := range testData {
+		t.Run(row.Input, func(t *testing.T) {
+			actual, ok := safeMemberPath(row.Input)
+			if ok != row.OK || actual != row.Expected {
+				t.Errorf("expected (%q, %v), got (%q, %v)", row.Expected, row.OK, actual, ok)
+			}
+		})
+	}
+}
+
+func TestWalkTar_SkipsLinks(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	write := func(hdr *tar.Header, body string) {
+		hdr.Size = int64(len(body))
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(&tar.Header{Typeflag: tar.TypeDir, Name: "bin/", Mode: 0o755}, "")
+	write(&tar.Header{Typeflag: tar.TypeReg, Name: "bin/tool", Mode: 0o755}, "#!/bin/sh\n")
+	write(&tar.Header{Typeflag: tar.TypeSymlink, Name: "bin/passwd", Linkname: "/etc/passwd", Mode: 0o777}, "")
+	write(&tar.Header{Typeflag: tar.TypeLink, Name: "bin/shadow", Linkname: "/etc/shadow", Mode: 0o644}, "")
+	write(&tar.Header{Typeflag: tar.TypeReg, Name: "README", Mode: 0o644}, "hello\n")
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath := filepath.Join(t.TempDir(), "test.tar")
+	if err := os.WriteFile(archivePath, buf.Bytes(), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	names, executables := walkMembers(t, walkTarPlain, archivePath)
+	expectNames(t, names, []string{"README", "bin/tool"})
+	if !executables["bin/tool"] || executables["README"] {
+		t.Errorf("wrong executable bits: %v", executables)
+	}
+}
+
+func TestWalkZip_SkipsLinks(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	write := func(name string, mode fs.FileMode, body string) {
+		hdr := &zip.FileHeader{Name: name, Method: zip.Deflate}
+		hdr.SetMode(mode)
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("bin/tool", 0o755, "#!/bin/sh\n")
+	write("bin/passwd", fs.ModeSymlink|0o777, "/etc/passwd")
+	write("README", 0o644, "hello\n")
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	archivePath := filepath.Join(t.TempDir(), "test.zip")
+	if err := os.WriteFile(archivePath, buf.Bytes(), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	names, _ := walkMembers(t, walkZip, archivePath)
+	expectNames(t, names, []string{"README", "bin/tool"})
+}
+
+func walkMembers(t *testing.T, walk func(string, func(archiveMember) error) error, archivePath string) ([]string, map[string]bool) {
+	t.Helper()
+	var names []string
+	executables := make(map[string]bool)
+	err := walk(archivePath, func(member archiveMember) error {
+		names = append(names, member.Name)
+		executables[member.Name] = member.Executable
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	return names, executables
+}
+
+func expectNames(t *testing.T, actual []string, expected []string) {
+	t.Helper()
+	if len(actual) != len(expected) {
+		t.Fatalf("expected members %q, got %q", expected, actual)
+	}
+	for index := range actual {
+		if actual[index] != expected[index] {
+			t.Fatalf("expected members %q, got %q", expected, actual)
+		}
+	}
+}
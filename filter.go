@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/chronos-tachyon/github-asset-mirror/indexfile"
+)
+
+type ReleaseFilters struct {
+	SkipPrereleases bool                   `yaml:"skipPrereleases,omitempty"`
+	IncludeTags     []string               `yaml:"includeTags,omitempty"`
+	ExcludeTags     []string               `yaml:"excludeTags,omitempty"`
+	Versions        indexfile.VersionRange `yaml:"versions,omitempty"`
+	LatestMinors    int                    `yaml:"latestMinors,omitempty"`
+	PublishedAfter  time.Time              `yaml:"publishedAfter,omitempty"`
+	Assets          AssetFilters           `yaml:"assets,omitempty"`
+}
+
+func (f *ReleaseFilters) Validate() error {
+	for _, pattern := range f.IncludeTags {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("filters.includeTags: %q: %w", pattern, err)
+		}
+	}
+	for _, pattern := range f.ExcludeTags {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("filters.excludeTags: %q: %w", pattern, err)
+		}
+	}
+	if f.LatestMinors < 0 {
+		return fmt.Errorf("filters.latestMinors: must not be negative, got %d", f.LatestMinors)
+	}
+	return f.Assets.Validate()
+}
+
+// Match reports whether a single release passes the filters that can be
+// decided from the release alone.  Releases whose publication date is not
+// known are not excluded by publishedAfter.
+func (f *ReleaseFilters) Match(release indexfile.Release) bool {
+	prerelease := release.Prerelease || release.Version.Prerelease != ""
+	if prerelease && f.SkipPrereleases {
+		return false
+	}
+	if len(f.IncludeTags) != 0 && !matchAny(f.IncludeTags, release.Tag) {
+		return false
+	}
+	if matchAny(f.ExcludeTags, release.Tag) {
+		return false
+	}
+	if !f.Versions.Contains(release.Version) {
+		return false
+	}
+	if !f.PublishedAfter.IsZero() && release.PublishedAt != nil && !release.PublishedAt.After(f.PublishedAfter) {
+		return false
+	}
+	return true
+}
+
+// Apply returns the releases that pass all filters, including those such as
+// latestMinors that depend on the other releases in the list.
+func (f *ReleaseFilters) Apply(releases []indexfile.Release) []indexfile.Release {
+	out := make([]indexfile.Release, 0, len(releases))
+	for _, release := range releases {
+		if f.Match(release) {
+			out = append(out, release)
+		}
+	}
+	if f.LatestMinors <= 0 {
+		return out
+	}
+
+	type minor struct{ Major, Minor uint }
+	minors := make([]minor, 0, len(out))
+	seen := make(map[minor]bool, len(out))
+	for _, release := range out {
+		key := minor{release.Version.Major, release.Version.Minor}
+		if !seen[key] {
+			seen[key] = true
+			minors = append(minors, key)
+		}
+	}
+	sort.Slice(minors, func(i, j int) bool {
+		if minors[i].Major != minors[j].Major {
+			return minors[i].Major > minors[j].Major
+		}
+		return minors[i].Minor > minors[j].Minor
+	})
+
+	keep := make(map[minor]bool, f.LatestMinors)
+	for index := 0; index < len(minors) && index < f.LatestMinors; index++ {
+		keep[minors[index]] = true
+	}
+
+	filtered := out[:0]
+	for _, release := range out {
+		if keep[minor{release.Version.Major, release.Version.Minor}] {
+			filtered = append(filtered, release)
+		}
+	}
+	return filtered
+}
+
+// AssetFilters decide which assets of a mirrored release are downloaded.
+// Each non-empty list must contain a match for the asset.  Assets for any OS
+// or architecture, such as checksum files and source archives, pass the OS
+// and Arch filters.
+type AssetFilters struct {
+	OS           []indexfile.AssetOS   `yaml:"os,omitempty"`
+	Arch         []indexfile.AssetArch `yaml:"arch,omitempty"`
+	Type         []indexfile.AssetType `yaml:"type,omitempty"`
+	Base         []string              `yaml:"base,omitempty"`
+	IncludeNames []string              `yaml:"includeNames,omitempty"`
+	ExcludeNames []string              `yaml:"excludeNames,omitempty"`
+	ListExcluded bool                  `yaml:"listExcluded,omitempty"`
+}
+
+func (f *AssetFilters) Validate() error {
+	for _, pattern := range f.Base {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("filters.assets.base: %q: %w", pattern, err)
+		}
+	}
+	for _, pattern := range f.IncludeNames {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("filters.assets.includeNames: %q: %w", pattern, err)
+		}
+	}
+	for _, pattern := range f.ExcludeNames {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("filters.assets.excludeNames: %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Match reports whether the asset should be downloaded.
+func (f *AssetFilters) Match(asset indexfile.Asset) bool {
+	if len(f.OS) != 0 && asset.OS != indexfile.AnyOS && !containsValue(f.OS, asset.OS) {
+		return false
+	}
+	if len(f.Arch) != 0 && asset.Arch != indexfile.AnyArch && !containsValue(f.Arch, asset.Arch) {
+		return false
+	}
+	if len(f.Type) != 0 && !containsValue(f.Type, asset.Type) {
+		return false
+	}
+	if len(f.Base) != 0 && !matchAny(f.Base, asset.Base) {
+		return false
+	}
+	if len(f.IncludeNames) != 0 && !matchAny(f.IncludeNames, asset.Name) {
+		return false
+	}
+	if matchAny(f.ExcludeNames, asset.Name) {
+		return false
+	}
+	return true
+}
+
+func containsValue[T comparable](list []T, value T) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, str string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, str); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"github.com/chronos-tachyon/github-asset-mirror/indexfile"
+	"github.com/chronos-tachyon/github-asset-mirror/indexutil"
+)
+
+// Kinds of garbage found by CollectGarbage.
+const (
+	UnreferencedGarbage = "unreferenced"
+	TempFileGarbage     = "tempFile"
+	PartialGarbage      = "partial"
+	EmptyDirGarbage     = "emptyDir"
+)
+
+// CollectGarbage walks a repository's output directory and finds the files
+// that index.json does not account for: files of assets that are no longer
+// listed, ".tmp.*~" files left behind by an interrupted write, partial
+// downloads of assets that are gone or complete, and directories left empty.
+// The attic, and the output directories of other repositories nested inside
+// this one, are left alone.  Nothing is removed unless apply is true.  It
+// returns the number of items found.
+func CollectGarbage(ctx context.Context, cfg *Config, repo *RepoConfig, apply bool) (int, error) {
+	outputDir := cfg.RepoOutputDir(repo)
+	logger := zerolog.Ctx(ctx).With().
+		Str("githubOwner", repo.Owner).
+		Str("githubRepo", repo.Repo).
+		Str("outputDir", outputDir).
+		Logger()
+
+	indexFilePath := filepath.Join(outputDir, indexfile.IndexFileName)
+	raw, err := os.ReadFile(indexFilePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		logger.Warn().
+			Msg("no JSON index file; skipping garbage collection for a repository that has never been mirrored")
+		return 0, nil
+	}
+	if err != nil {
+		logger.Error().
+			Str("path", indexFilePath).
+			Err(err).
+			Msg("failed to read contents of JSON index file")
+		return 0, err
+	}
+
+	var releases []indexfile.Release
+	ctx2 := logger.With().Str("path", indexFilePath).Logger().WithContext(ctx)
+	err = indexutil.FromJSON(ctx2, &releases, raw)
+	if err != nil {
+		return 0, err
+	}
+
+	referenced := referencedFiles(outputDir, releases)
+
+	skipDirs := make(map[string]bool, len(cfg.Repos))
+	skipDirs[filepath.Join(outputDir, AtticDirName)] = true
+	for index := range cfg.Repos {
+		other := cfg.RepoOutputDir(&cfg.Repos[index])
+		if other != outputDir {
+			skipDirs[other] = true
+		}
+	}
+
+	type garbage struct {
+		Path string
+		Kind string
+	}
+
+	var found []garbage
+	var dirs []string
+	numEntries := make(map[string]int, 64)
+	err = filepath.WalkDir(outputDir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if filePath == outputDir {
+			return nil
+		}
+
+		parent := filepath.Dir(filePath)
+		if d.IsDir() {
+			numEntries[parent]++
+			if skipDirs[filePath] {
+				return filepath.SkipDir
+			}
+			dirs = append(dirs, filePath)
+			return nil
+		}
+
+		rel, err := filepath.Rel(outputDir, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if referenced[rel] {
+			numEntries[parent]++
+			return nil
+		}
+
+		kind := UnreferencedGarbage
+		switch {
+		case isTempFileName(d.Name()):
+			kind = TempFileGarbage
+		case strings.HasPrefix(rel, StagingDirName+"/"):
+			kind = PartialGarbage
+		}
+		found = append(found, garbage{Path: filePath, Kind: kind})
+		return nil
+	})
+	if err != nil {
+		logger.Error().
+			Err(err).
+			Msg("failed to walk output directory")
+		return 0, err
+	}
+
+	// WalkDir visits parents before their children, so walking the list
+	// backwards sees each directory only after everything inside it.
+	for index := len(dirs) - 1; index >= 0; index-- {
+		dir := dirs[index]
+		if numEntries[dir] == 0 {
+			numEntries[filepath.Dir(dir)]--
+			found = append(found, garbage{Path: dir, Kind: EmptyDirGarbage})
+		}
+	}
+
+	var firstErr error
+	for _, item := range found {
+		itemLogger := logger.With().
+			Str("path", item.Path).
+			Str("kind", item.Kind).
+			Logger()
+
+		if !apply {
+			itemLogger.Info().
+				Msg("found garbage; rerun with --apply to remove it")
+			continue
+		}
+
+		err := os.Remove(item.Path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			itemLogger.Error().
+				Err(err).
+				Msg("failed to remove garbage")
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		itemLogger.Info().
+			Msg("removed garbage")
+	}
+	return len(found), firstErr
+}
+
+// referencedFiles returns the slash-separated paths, relative to outputDir,
+// of every file that the index accounts for.  Partial downloads count only
+// while the asset they belong to is still missing.
+func referencedFiles(outputDir string, releases []indexfile.Release) map[string]bool {
+	out := make(map[string]bool, 64)
+	out[indexfile.IndexFileName] = true
+	for _, release := range releases {
+		for _, asset := range release.Assets {
+			if asset.NotMirrored {
+				continue
+			}
+			if asset.Quarantined() {
+				out[path.Join(QuarantineDirName, release.Tag, asset.Name)] = true
+				continue
+			}
+
+			assetPath := path.Join(release.Tag, asset.Name)
+			out[assetPath] = true
+			if asset.Derived() {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(outputDir, filepath.FromSlash(assetPath))); errors.Is(err, fs.ErrNotExist) {
+				stagedPath := path.Join(StagingDirName, release.Tag, asset.Name)
+				out[stagedPath] = true
+				out[stagedPath+stagingStateSuffix] = true
+			}
+		}
+	}
+	return out
+}
+
+// isTempFileName reports whether name is a temporary file created by
+// indexutil.WriteFileFrom.
+func isTempFileName(name string) bool {
+	return strings.HasPrefix(name, ".tmp.") && strings.HasSuffix(name, "~")
+}
@@ -4,21 +4,27 @@ import (
 	"github.com/google/go-github/v48/github"
 )
 
-type CallFunc[T any] func(*github.ListOptions) ([]*T, *github.Response)
+type CallFunc[T any] func(*github.ListOptions) ([]*T, *github.Response, error)
 
-type ProcessFunc[T any] func(*T)
+type ProcessFunc[T any] func(*T) error
 
-func Iterate[T any](pageSize int, callFn CallFunc[T], processFn ProcessFunc[T]) {
+func Iterate[T any](pageSize int, callFn CallFunc[T], processFn ProcessFunc[T]) error {
 	var options github.ListOptions
 	options.Page = 0
 	options.PerPage = pageSize
 	for {
-		list, resp := callFn(&options)
+		list, resp, err := callFn(&options)
 		for _, item := range list {
-			processFn(item)
+			err2 := processFn(item)
+			if err2 != nil {
+				return err2
+			}
+		}
+		if err != nil {
+			return err
 		}
 		if resp.NextPage == 0 {
-			return
+			return nil
 		}
 		options.Page = resp.NextPage
 	}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/google/go-github/v48/github"
+)
+
+// DefaultGitHubURL is the API base URL of public GitHub.
+const DefaultGitHubURL = "https://api.github.com/"
+
+// HostConfig holds the settings for one GitHub API host, keyed in
+// Config.Hosts by the host name of its API base URL.
+type HostConfig struct {
+	TokenFile    string     `yaml:"tokenFile,omitempty"`
+	App          *AppConfig `yaml:"app,omitempty"`
+	TokenCommand []string   `yaml:"tokenCommand,omitempty"`
+	Anonymous    bool       `yaml:"anonymous,omitempty"`
+	UploadURL    string     `yaml:"uploadURL,omitempty"`
+	CABundle     string     `yaml:"caBundle,omitempty"`
+}
+
+// HasCredentials reports whether any way of authenticating, including
+// explicitly not authenticating, is configured.
+func (h HostConfig) HasCredentials() bool {
+	return h.TokenFile != "" || h.App != nil || len(h.TokenCommand) != 0 || h.Anonymous
+}
+
+func (h HostConfig) Validate() error {
+	numCredentials := 0
+	for _, set := range []bool{h.TokenFile != "", h.App != nil, len(h.TokenCommand) != 0, h.Anonymous} {
+		if set {
+			numCredentials++
+		}
+	}
+	if numCredentials > 1 {
+		return errors.New("tokenFile, app, tokenCommand and anonymous are mutually exclusive")
+	}
+	if h.App != nil {
+		if err := h.App.Validate(); err != nil {
+			return err
+		}
+	}
+	if h.UploadURL != "" {
+		return validateURL("uploadURL", h.UploadURL)
+	}
+	return nil
+}
+
+func validateURL(field string, str string) error {
+	u, err := url.Parse(str)
+	if err != nil {
+		return fmt.Errorf("%s: %w", field, err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return fmt.Errorf("%s: must be an http:// or https:// URL, got %q", field, str)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%s: missing host in %q", field, str)
+	}
+	return nil
+}
+
+// HostName returns the lower-cased host, including any port, of an API base
+// URL.
+func HostName(apiURL string) string {
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
+
+// NewTransport returns the HTTP transport to use for a GitHub host.  If
+// caBundle names a PEM file, its certificates are trusted in addition to the
+// system roots.
+func NewTransport(caBundle string) (http.RoundTripper, error) {
+	if caBundle == "" {
+		return http.DefaultTransport, nil
+	}
+
+	raw, err := os.ReadFile(caBundle)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(raw) {
+		return nil, fmt.Errorf("%s: no PEM-encoded certificates found", caBundle)
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+	return t, nil
+}
+
+// NewGitHubClient returns a client for public GitHub, or for the GitHub
+// Enterprise Server instance at apiURL.
+func NewGitHubClient(apiURL string, uploadURL string, httpClient *http.Client) (*github.Client, error) {
+	if HostName(apiURL) == HostName(DefaultGitHubURL) && uploadURL == "" {
+		return github.NewClient(httpClient), nil
+	}
+	if uploadURL == "" {
+		uploadURL = apiURL
+	}
+	return github.NewEnterpriseClient(apiURL, uploadURL, httpClient)
+}
+
+// NewAuthenticatedClient returns a GitHub API client, and the HTTP client
+// beneath it, which send credentials from source to the API host only.
+func NewAuthenticatedClient(transport http.RoundTripper, source TokenSource, apiURL string, uploadURL string) (*github.Client, *http.Client, error) {
+	rt := &MyRoundTripper{Next: transport, Source: source}
+	httpClient := &http.Client{Transport: rt, CheckRedirect: StripAuthOnRedirect}
+	client, err := NewGitHubClient(apiURL, uploadURL, httpClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	rt.Hosts = []string{client.BaseURL.Host}
+	return client, httpClient, nil
+}
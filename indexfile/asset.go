@@ -1,39 +1,42 @@
 package indexfile
 
 import (
-	"bytes"
-	"context"
 	"io/fs"
-	"os/exec"
 	"path/filepath"
-	"regexp"
 )
 
-var (
-	reAssetExecutable = regexp.MustCompile(`^([0-9A-Za-z]+(?:[_-][0-9A-Za-z]+)*)-(linux)-(amd64|arm64)(?:\.exe)?$`)
-	reAssetProvenance = regexp.MustCompile(`^([0-9A-Za-z]+(?:[_-][0-9A-Za-z]+)*)-(linux)-(amd64|arm64)\.intoto\.jsonl?$`)
-	reBuildID         = regexp.MustCompile(`^\tbuild\tvcs\.revision=([0-9a-f]{40})\s*$`)
-)
+type Asset struct {
+	ID     int64     `json:"id,omitempty"`
+	URL    string    `json:"url"`
+	Name   string    `json:"name"`
+	Base   string    `json:"base,omitempty"`
+	OS     AssetOS   `json:"os"`
+	Arch   AssetArch `json:"arch"`
+	Type   AssetType `json:"type"`
+	Libc   string    `json:"libc,omitempty"`
+	Size   int64     `json:"size,omitempty"`
+	SHA256 string    `json:"sha256,omitempty"`
+	SHA512 string    `json:"sha512,omitempty"`
 
-var osMap = map[string]AssetOS{
-	"any":   AnyOS,
-	"linux": LinuxOS,
-}
+	Checksum   VerifyStatus `json:"checksum,omitempty"`
+	Provenance VerifyStatus `json:"provenance,omitempty"`
+	Signature  VerifyStatus `json:"signature,omitempty"`
 
-var archMap = map[string]AssetArch{
-	"any":   AnyArch,
-	"amd64": AMD64Arch,
-	"arm64": ARM64Arch,
-}
+	BuildID   string     `json:"buildID,omitempty"`
+	BuildInfo *BuildInfo `json:"buildInfo,omitempty"`
 
-type Asset struct {
-	ID   int64     `json:"id,omitempty"`
-	URL  string    `json:"url"`
-	Name string    `json:"name"`
-	Base string    `json:"base,omitempty"`
-	OS   AssetOS   `json:"os"`
-	Arch AssetArch `json:"arch"`
-	Type AssetType `json:"type"`
+	// Parent is the name of the archive asset this file was extracted
+	// from, if any.
+	Parent string `json:"parent,omitempty"`
+
+	// ParentSHA256 is the SHA-256 digest of the archive at the time this
+	// file was extracted from it, so that a changed archive is unpacked
+	// again.
+	ParentSHA256 string `json:"parentSHA256,omitempty"`
+
+	// NotMirrored is set on assets that exist upstream but were excluded
+	// by the asset filters, and so have no local copy.
+	NotMirrored bool `json:"notMirrored,omitempty"`
 }
 
 func MakeSourceTarballAsset(assetURL string) Asset {
@@ -57,36 +60,47 @@ func MakeSourceZipballAsset(assetURL string) Asset {
 }
 
 func MakeAsset(assetID int64, assetURL string, assetName string) Asset {
-	assetBase := ""
-	assetOS := UnknownAssetOS
-	assetArch := UnknownAssetArch
-	assetType := UnknownAssetType
-
-	match := reAssetExecutable.FindStringSubmatch(assetName)
-	if match != nil {
-		assetBase = match[1]
-		assetOS = osMap[match[2]]
-		assetArch = archMap[match[3]]
-		assetType = ExecutableType
-	}
+	return DefaultNamingRules.MakeAsset(assetID, assetURL, assetName)
+}
 
-	match = reAssetProvenance.FindStringSubmatch(assetName)
-	if match != nil {
-		assetBase = match[1]
-		assetOS = osMap[match[2]]
-		assetArch = archMap[match[3]]
-		assetType = ProvenanceType
-	}
+// SameUpstream reports whether a and other describe the same upstream file.
+func (a Asset) SameUpstream(other Asset) bool {
+	return a.ID == other.ID && a.Name == other.Name
+}
 
-	return Asset{
-		ID:   assetID,
-		URL:  assetURL,
-		Name: assetName,
-		Base: assetBase,
-		OS:   assetOS,
-		Arch: assetArch,
-		Type: assetType,
-	}
+// CopyLocalState copies the fields that describe the mirrored copy of the
+// asset, rather than the upstream metadata, from old into a.
+func (a *Asset) CopyLocalState(old Asset) {
+	a.Size = old.Size
+	a.SHA256 = old.SHA256
+	a.SHA512 = old.SHA512
+	a.Checksum = old.Checksum
+	a.Provenance = old.Provenance
+	a.Signature = old.Signature
+	a.BuildID = old.BuildID
+	a.BuildInfo = old.BuildInfo
+}
+
+// Derived reports whether the asset was extracted from another asset rather
+// than published upstream.
+func (a Asset) Derived() bool {
+	return a.Parent != ""
+}
+
+// Quarantined reports whether the mirrored copy of the asset was moved aside
+// because it failed verification.
+func (a Asset) Quarantined() bool {
+	return a.Checksum == QuarantinedStatus || a.Provenance == QuarantinedStatus
+}
+
+// ResetLocalState clears the verification results, e.g. after the asset
+// has been downloaded again.
+func (a *Asset) ResetLocalState() {
+	a.Checksum = UnverifiedStatus
+	a.Provenance = UnverifiedStatus
+	a.Signature = UnverifiedStatus
+	a.BuildID = ""
+	a.BuildInfo = nil
 }
 
 func (a Asset) Mode() fs.FileMode {
@@ -98,19 +112,23 @@ func (a Asset) Mode() fs.FileMode {
 	}
 }
 
-func (a Asset) ExtractBuildID(ctx context.Context, releaseDir string) (string, bool) {
-	assetPath := filepath.Join(releaseDir, a.Name)
-	cmd := exec.CommandContext(ctx, "go", "version", "-m", assetPath)
-	raw, err := cmd.Output()
-	if err == nil {
-		for _, line := range bytes.Split(raw, []byte{'\n'}) {
-			match := reBuildID.FindStringSubmatch(string(line))
-			if match != nil {
-				return match[1], true
-			}
+// ExtractBuildID reads the Go build info embedded in the downloaded asset,
+// records it in a.BuildInfo and a.BuildID, and returns the VCS revision it
+// was built from.
+func (a *Asset) ExtractBuildID(releaseDir string) (string, bool) {
+	if a.BuildInfo == nil {
+		assetPath := filepath.Join(releaseDir, a.Name)
+		info, err := ReadBuildInfo(assetPath)
+		if err != nil {
+			return "", false
 		}
+		a.BuildInfo = info
+	}
+	a.BuildID = a.BuildInfo.Revision
+	if a.BuildID == "" {
+		return "", false
 	}
-	return "", false
+	return a.BuildID, true
 }
 
 func (a Asset) CompareTo(other Asset) CompareResult {
@@ -133,6 +151,12 @@ func (a Asset) CompareTo(other Asset) CompareResult {
 	if cmp == EQ {
 		cmp = CompareString(a.Base, other.Base)
 	}
+	if cmp == EQ {
+		cmp = CompareString(a.Libc, other.Libc)
+	}
+	if cmp == EQ {
+		cmp = CompareString(a.Parent, other.Parent)
+	}
 	return cmp
 }
 
@@ -0,0 +1,85 @@
+package indexfile
+
+import (
+	"debug/buildinfo"
+	"runtime/debug"
+)
+
+type BuildInfo struct {
+	GoVersion string         `json:"goVersion"`
+	Path      string         `json:"path,omitempty"`
+	Main      Module         `json:"main"`
+	VCS       string         `json:"vcs,omitempty"`
+	Revision  string         `json:"revision,omitempty"`
+	Time      string         `json:"time,omitempty"`
+	Modified  bool           `json:"modified,omitempty"`
+	Settings  []BuildSetting `json:"settings,omitempty"`
+	Deps      []Module       `json:"deps,omitempty"`
+}
+
+type Module struct {
+	Path    string  `json:"path"`
+	Version string  `json:"version,omitempty"`
+	Sum     string  `json:"sum,omitempty"`
+	Replace *Module `json:"replace,omitempty"`
+}
+
+type BuildSetting struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func ReadBuildInfo(filePath string) (*BuildInfo, error) {
+	info, err := buildinfo.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return MakeBuildInfo(info), nil
+}
+
+func MakeBuildInfo(info *debug.BuildInfo) *BuildInfo {
+	out := &BuildInfo{
+		GoVersion: info.GoVersion,
+		Path:      info.Path,
+		Main:      makeModule(&info.Main),
+	}
+
+	if len(info.Settings) != 0 {
+		out.Settings = make([]BuildSetting, len(info.Settings))
+		for index, setting := range info.Settings {
+			out.Settings[index] = BuildSetting{Key: setting.Key, Value: setting.Value}
+			switch setting.Key {
+			case "vcs":
+				out.VCS = setting.Value
+			case "vcs.revision":
+				out.Revision = setting.Value
+			case "vcs.time":
+				out.Time = setting.Value
+			case "vcs.modified":
+				out.Modified = (setting.Value == "true")
+			}
+		}
+	}
+
+	if len(info.Deps) != 0 {
+		out.Deps = make([]Module, len(info.Deps))
+		for index, dep := range info.Deps {
+			out.Deps[index] = makeModule(dep)
+		}
+	}
+
+	return out
+}
+
+func makeModule(mod *debug.Module) Module {
+	out := Module{
+		Path:    mod.Path,
+		Version: mod.Version,
+		Sum:     mod.Sum,
+	}
+	if mod.Replace != nil {
+		replace := makeModule(mod.Replace)
+		out.Replace = &replace
+	}
+	return out
+}
@@ -91,13 +91,41 @@ const (
 	UnknownAssetOS AssetOS = iota
 	AnyOS
 	LinuxOS
+	DarwinOS
+	WindowsOS
+	FreeBSDOS
+	OpenBSDOS
+	NetBSDOS
+	DragonflyOS
+	SolarisOS
+	IllumosOS
+	AIXOS
+	AndroidOS
+	IOSOS
+	Plan9OS
+	JSOS
+	WASIP1OS
 	NumAssetOSes
 )
 
 var assetOSDataArray = [NumAssetOSes]EnumData{
 	{"UnknownAssetOS", "unknown", []string{""}},
-	{"AnyOS", "any", nil},
+	{"AnyOS", "any", []string{"all"}},
 	{"LinuxOS", "linux", nil},
+	{"DarwinOS", "darwin", []string{"macos", "osx", "mac"}},
+	{"WindowsOS", "windows", []string{"win", "win32", "win64"}},
+	{"FreeBSDOS", "freebsd", nil},
+	{"OpenBSDOS", "openbsd", nil},
+	{"NetBSDOS", "netbsd", nil},
+	{"DragonflyOS", "dragonfly", []string{"dragonflybsd"}},
+	{"SolarisOS", "solaris", nil},
+	{"IllumosOS", "illumos", nil},
+	{"AIXOS", "aix", nil},
+	{"AndroidOS", "android", nil},
+	{"IOSOS", "ios", nil},
+	{"Plan9OS", "plan9", nil},
+	{"JSOS", "js", nil},
+	{"WASIP1OS", "wasip1", []string{"wasi"}},
 }
 
 func (value AssetOS) Data() EnumData {
@@ -161,14 +189,42 @@ const (
 	AnyArch
 	AMD64Arch
 	ARM64Arch
+	I386Arch
+	ARMArch
+	ARMv6Arch
+	ARMv7Arch
+	RISCV64Arch
+	PPC64Arch
+	PPC64LEArch
+	S390XArch
+	MIPSArch
+	MIPSLEArch
+	MIPS64Arch
+	MIPS64LEArch
+	Loong64Arch
+	WASMArch
 	NumAssetArches
 )
 
 var assetArchDataArray = [NumAssetArches]EnumData{
 	{"UnknownAssetArch", "unknown", []string{""}},
-	{"AnyArch", "any", nil},
-	{"AMD64Arch", "amd64", []string{"x86-64", "x64"}},
-	{"ARM64Arch", "arm64", []string{"aarch64"}},
+	{"AnyArch", "any", []string{"all", "universal", "noarch"}},
+	{"AMD64Arch", "amd64", []string{"x86-64", "x86_64", "x64"}},
+	{"ARM64Arch", "arm64", []string{"aarch64", "armv8", "arm64v8"}},
+	{"I386Arch", "386", []string{"i386", "i486", "i586", "i686", "x86"}},
+	{"ARMArch", "arm", []string{"armel", "armv5"}},
+	{"ARMv6Arch", "armv6", []string{"armv6l", "armv6hf", "arm6"}},
+	{"ARMv7Arch", "armv7", []string{"armv7l", "armv7hf", "armv7hl", "armhf", "arm7"}},
+	{"RISCV64Arch", "riscv64", []string{"riscv64gc"}},
+	{"PPC64Arch", "ppc64", nil},
+	{"PPC64LEArch", "ppc64le", []string{"ppc64el", "powerpc64le"}},
+	{"S390XArch", "s390x", nil},
+	{"MIPSArch", "mips", nil},
+	{"MIPSLEArch", "mipsle", []string{"mipsel"}},
+	{"MIPS64Arch", "mips64", nil},
+	{"MIPS64LEArch", "mips64le", []string{"mips64el"}},
+	{"Loong64Arch", "loong64", []string{"loongarch64"}},
+	{"WASMArch", "wasm", []string{"wasm32"}},
 }
 
 func (value AssetArch) Data() EnumData {
@@ -233,6 +289,12 @@ const (
 	SourceZipType
 	ExecutableType
 	ProvenanceType
+	ChecksumType
+	ArchiveType
+	PackageType
+	SignatureType
+	CertificateType
+	SBOMType
 	NumAssetTypes
 )
 
@@ -242,6 +304,12 @@ var assetTypeDataArray = [NumAssetTypes]EnumData{
 	{"SourceZipType", "source-zip", []string{"sourcezip"}},
 	{"ExecutableType", "executable", []string{"binary"}},
 	{"ProvenanceType", "provenance", nil},
+	{"ChecksumType", "checksum", []string{"checksums", "sums"}},
+	{"ArchiveType", "archive", []string{"tarball", "zipball"}},
+	{"PackageType", "package", []string{"pkg", "deb", "rpm", "apk"}},
+	{"SignatureType", "signature", []string{"sig", "asc"}},
+	{"CertificateType", "certificate", []string{"cert", "pem"}},
+	{"SBOMType", "sbom", []string{"spdx", "cyclonedx"}},
 }
 
 func (value AssetType) Data() EnumData {
@@ -298,6 +366,77 @@ var (
 	_ ComparableTo[AssetType]  = AssetType(0)
 )
 
+type VerifyStatus byte
+
+const (
+	UnverifiedStatus VerifyStatus = iota
+	VerifiedStatus
+	MismatchStatus
+	QuarantinedStatus
+	NumVerifyStatuses
+)
+
+var verifyStatusDataArray = [NumVerifyStatuses]EnumData{
+	{"UnverifiedStatus", "unverified", []string{""}},
+	{"VerifiedStatus", "verified", []string{"ok"}},
+	{"MismatchStatus", "mismatch", []string{"failed"}},
+	{"QuarantinedStatus", "quarantined", nil},
+}
+
+func (value VerifyStatus) Data() EnumData {
+	if value < NumVerifyStatuses {
+		return verifyStatusDataArray[value]
+	}
+	goName := fmt.Sprintf("VerifyStatus(0x%02x)", uint(value))
+	name := fmt.Sprintf("verify-status-%02x", uint(value))
+	return EnumData{goName, name, nil}
+}
+
+func (value VerifyStatus) GoString() string {
+	return value.Data().GoName
+}
+
+func (value VerifyStatus) String() string {
+	return value.Data().Name
+}
+
+func (value VerifyStatus) MarshalText() ([]byte, error) {
+	str := value.String()
+	return []byte(str), nil
+}
+
+func (value *VerifyStatus) UnmarshalText(raw []byte) error {
+	raw = bytes.TrimSpace(raw)
+	str := string(raw)
+	for enum := VerifyStatus(0); enum < NumVerifyStatuses; enum++ {
+		data := verifyStatusDataArray[enum]
+		if str == data.GoName || strings.EqualFold(str, data.Name) {
+			*value = enum
+			return nil
+		}
+		for _, alias := range data.Aliases {
+			if strings.EqualFold(str, alias) {
+				*value = enum
+				return nil
+			}
+		}
+	}
+	*value = 0
+	return fmt.Errorf("failed to parse %q as VerifyStatus", str)
+}
+
+func (value VerifyStatus) CompareTo(other VerifyStatus) CompareResult {
+	return CompareByte(value, other)
+}
+
+var (
+	_ fmt.GoStringer             = VerifyStatus(0)
+	_ fmt.Stringer               = VerifyStatus(0)
+	_ encoding.TextMarshaler     = VerifyStatus(0)
+	_ encoding.TextUnmarshaler   = (*VerifyStatus)(nil)
+	_ ComparableTo[VerifyStatus] = VerifyStatus(0)
+)
+
 type VersionElementType byte
 
 const (
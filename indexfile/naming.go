@@ -0,0 +1,329 @@
+package indexfile
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const reAssetBase = `[0-9A-Za-z]+(?:[_-][0-9A-Za-z]+)*`
+
+// reAssetBaseLazy is reAssetBase, matching as little as it can.
+const reAssetBaseLazy = `[0-9A-Za-z]+?(?:[_-][0-9A-Za-z]+?)*?`
+
+// reAssetVersion matches a version between the base name and the platform,
+// either after a separator ("tool-1.2.3", "tool_v2") or, if it has a dot,
+// directly after the base ("go1.21.5").
+const reAssetVersion = `(?:[-_.]v?[0-9][0-9A-Za-z.+~]*|[0-9]+\.[0-9][0-9A-Za-z.+~]*)`
+
+var (
+	reAssetOS        = enumAlternation(assetOSDataArray[AnyOS+1:])
+	reAssetArch      = enumAlternation(assetArchDataArray[AnyArch+1:])
+	reAssetArchOrAny = enumAlternation(assetArchDataArray[AnyArch:])
+
+	reAssetChecksums = regexp.MustCompile(`(?i)^(?:[0-9A-Za-z._-]*[._-])?(?:sha(?:256|512)sums?|checksums?)(?:\.txt)?$`)
+)
+
+// assetTypeSuffixes maps well-known file name suffixes to the type of asset
+// they denote, longest first.  A detached suffix marks a file that describes
+// the asset named by stripping the suffix, e.g. "foo.tar.gz.sig".
+var assetTypeSuffixes = []struct {
+	Suffix   string
+	Type     AssetType
+	Detached bool
+}{
+	{".sha256sum", ChecksumType, true},
+	{".sha512sum", ChecksumType, true},
+	{".sha256", ChecksumType, true},
+	{".sha512", ChecksumType, true},
+	{".sig", SignatureType, true},
+	{".asc", SignatureType, true},
+	{".pem", CertificateType, true},
+	{".crt", CertificateType, true},
+	{".cyclonedx.json", SBOMType, true},
+	{".sbom.json", SBOMType, true},
+	{".spdx.json", SBOMType, true},
+	{".cdx.json", SBOMType, true},
+	{".spdx", SBOMType, true},
+	{".intoto.jsonl", ProvenanceType, false},
+	{".intoto.json", ProvenanceType, false},
+	{".pkg.tar.zst", PackageType, false},
+	{".deb", PackageType, false},
+	{".rpm", PackageType, false},
+	{".apk", PackageType, false},
+	{".msi", PackageType, false},
+	{".pkg", PackageType, false},
+	{".dmg", PackageType, false},
+	{".tar.gz", ArchiveType, false},
+	{".tar.xz", ArchiveType, false},
+	{".tar.bz2", ArchiveType, false},
+	{".tar.zst", ArchiveType, false},
+	{".tgz", ArchiveType, false},
+	{".txz", ArchiveType, false},
+	{".tbz2", ArchiveType, false},
+	{".tar", ArchiveType, false},
+	{".zip", ArchiveType, false},
+	{".exe", ExecutableType, false},
+}
+
+var reAssetSuffix = func() string {
+	suffixes := make([]string, 0, len(assetTypeSuffixes))
+	for _, row := range assetTypeSuffixes {
+		if !row.Detached {
+			suffixes = append(suffixes, regexp.QuoteMeta(row.Suffix))
+		}
+	}
+	return `(?i:` + strings.Join(suffixes, "|") + `)`
+}()
+
+// lookupAssetSuffix returns the entry in assetTypeSuffixes matching the end
+// of assetName, if any.
+func lookupAssetSuffix(assetName string) (suffix string, assetType AssetType, detached bool, found bool) {
+	lower := strings.ToLower(assetName)
+	for _, row := range assetTypeSuffixes {
+		if strings.HasSuffix(lower, row.Suffix) {
+			return row.Suffix, row.Type, row.Detached, true
+		}
+	}
+	return "", UnknownAssetType, false, false
+}
+
+// InferAssetType guesses the type of an asset from its file name suffix.
+// Names without a recognised suffix are assumed to be executables.
+func InferAssetType(assetName string) AssetType {
+	if _, assetType, _, found := lookupAssetSuffix(assetName); found {
+		return assetType
+	}
+	return ExecutableType
+}
+
+// enumAlternation returns a case-insensitive regular expression alternation
+// matching the names and aliases of the given enum values.  Longer names are
+// tried first, so that e.g. "armv7" is preferred over "arm".
+func enumAlternation(list []EnumData) string {
+	names := make([]string, 0, 4*len(list))
+	for _, data := range list {
+		names = append(names, data.Name)
+		for _, alias := range data.Aliases {
+			if alias != "" {
+				names = append(names, alias)
+			}
+		}
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return len(names[i]) > len(names[j])
+	})
+	for index := range names {
+		names[index] = regexp.QuoteMeta(names[index])
+	}
+	return `(?i:` + strings.Join(names, "|") + `)`
+}
+
+// NamingRule classifies assets whose names match Pattern.  The named
+// captures "base", "os", "arch", "type" and "libc" fill in the corresponding
+// Asset fields; fields without a capture fall back to the rule's fixed
+// values, and the type is otherwise inferred from the file name suffix.
+type NamingRule struct {
+	Pattern *regexp.Regexp
+	OS      AssetOS
+	Arch    AssetArch
+	Type    AssetType
+	Libc    string
+}
+
+func (rule NamingRule) Apply(a *Asset) bool {
+	match := rule.Pattern.FindStringSubmatch(a.Name)
+	if match == nil {
+		return false
+	}
+
+	a.Base = ""
+	a.OS = rule.OS
+	a.Arch = rule.Arch
+	a.Type = rule.Type
+	a.Libc = rule.Libc
+	if a.Type == UnknownAssetType {
+		a.Type = InferAssetType(a.Name)
+	}
+
+	for index, name := range rule.Pattern.SubexpNames() {
+		value := match[index]
+		if value == "" {
+			continue
+		}
+		switch name {
+		case "base":
+			a.Base = value
+		case "os":
+			a.OS = parseAssetOS(value)
+		case "arch":
+			a.Arch = parseAssetArch(value)
+		case "type":
+			_ = a.Type.UnmarshalText([]byte(value))
+		case "libc":
+			a.Libc = strings.ToLower(value)
+		}
+	}
+	return true
+}
+
+type NamingRules []NamingRule
+
+// MakeAsset classifies an asset by the first rule whose pattern matches its
+// name.  Checksum files are recognised regardless of the rules.
+func (rules NamingRules) MakeAsset(assetID int64, assetURL string, assetName string) Asset {
+	a := Asset{
+		ID:   assetID,
+		URL:  assetURL,
+		Name: assetName,
+		OS:   UnknownAssetOS,
+		Arch: UnknownAssetArch,
+		Type: UnknownAssetType,
+	}
+
+	// Detached checksums, signatures and the like take their base, OS and
+	// arch from the file they describe.  Those describing nothing in
+	// particular, such as "SHA256SUMS.asc", apply to every platform.
+	suffix, assetType, detached, _ := lookupAssetSuffix(assetName)
+	if detached && len(assetName) > len(suffix) {
+		target := rules.MakeAsset(0, "", assetName[:len(assetName)-len(suffix)])
+		a.Base = target.Base
+		a.OS = target.OS
+		a.Arch = target.Arch
+		a.Libc = target.Libc
+		a.Type = assetType
+		if a.OS == UnknownAssetOS && a.Arch == UnknownAssetArch {
+			a.OS = AnyOS
+			a.Arch = AnyArch
+		}
+		return a
+	}
+
+	if reAssetChecksums.MatchString(assetName) {
+		a.OS = AnyOS
+		a.Arch = AnyArch
+		a.Type = ChecksumType
+		return a
+	}
+
+	for _, rule := range rules {
+		if rule.Apply(&a) {
+			return a
+		}
+	}
+
+	// Even when no rule matches, a well-known suffix still says what kind
+	// of file this is.
+	a.Type = assetType
+	return a
+}
+
+// MakeDerivedAsset classifies a file extracted from the archive parent.
+// memberPath is the file's path relative to the release directory.  Members
+// whose names say nothing about their platform, such as a bare "foo", take
+// the base, OS, arch and libc of the archive, and files with no recognised
+// suffix are executables only if executable is true.
+func (rules NamingRules) MakeDerivedAsset(parent Asset, memberPath string, executable bool) Asset {
+	a := rules.MakeAsset(0, "", path.Base(memberPath))
+	a.Name = memberPath
+	a.Parent = parent.Name
+	a.ParentSHA256 = parent.SHA256
+	if a.Base == "" {
+		a.Base = parent.Base
+	}
+	if a.OS == UnknownAssetOS {
+		a.OS = parent.OS
+		a.Libc = parent.Libc
+	}
+	if a.Arch == UnknownAssetArch {
+		a.Arch = parent.Arch
+	}
+	if a.Type == UnknownAssetType && executable {
+		a.Type = ExecutableType
+	}
+	return a
+}
+
+// packageNamingRules classify Linux packages by their conventional file
+// names, which give the architecture but not the OS: Debian's (and
+// GoReleaser's Alpine) "<name>_<version>_<arch>.deb", optionally with the OS
+// before the architecture, and RPM's "<name>-<version>-<release>.<arch>.rpm".
+// Architecture-independent packages ("all", "noarch") are for any arch.
+var packageNamingRules = NamingRules{
+	{
+		Pattern: regexp.MustCompile(`^(?P<base>[0-9A-Za-z][0-9A-Za-z.+-]*)_v?[0-9][0-9A-Za-z.+~-]*(?:_(?P<os>` + reAssetOS + `))?_(?P<arch>` + reAssetArchOrAny + `)(?i:\.deb|\.apk)$`),
+		OS:      LinuxOS,
+	},
+	{
+		Pattern: regexp.MustCompile(`^(?P<base>[0-9A-Za-z][0-9A-Za-z._+-]*?)-v?[0-9][0-9A-Za-z.+~_]*(?:-[0-9A-Za-z.+~_]+)?\.(?P<arch>` + reAssetArchOrAny + `)(?i:\.rpm)$`),
+		OS:      LinuxOS,
+	},
+}
+
+var namingPresets = map[string]NamingRules{
+	// The original "<base>-<os>-<arch>" convention, optionally with a
+	// version after the base as in Go's own "go1.21.5.linux-amd64", and
+	// followed by a well-known suffix such as ".exe" or ".tar.gz".  The base
+	// is matched lazily, so that the version is not taken as part of it.
+	"default": append(NamingRules{
+		{
+			Pattern: regexp.MustCompile(`^(?P<base>` + reAssetBaseLazy + `)` + reAssetVersion + `?[-_.](?P<os>` + reAssetOS + `)[-_](?P<arch>` + reAssetArch + `)` + reAssetSuffix + `?$`),
+		},
+	}, packageNamingRules...),
+
+	// GoReleaser: "<base>_<version>_<Os>_<arch>.tar.gz", with an optional
+	// version, plus the packages built by its nFPM integration.  macOS
+	// universal binaries have the arch "all".
+	"goreleaser": append(NamingRules{
+		{
+			Pattern: regexp.MustCompile(`^(?P<base>` + reAssetBase + `)(?:_v?[0-9][0-9A-Za-z.+~-]*)?_(?P<os>` + reAssetOS + `)_(?P<arch>` + reAssetArchOrAny + `)` + reAssetSuffix + `?$`),
+		},
+	}, packageNamingRules...),
+
+	// cargo-dist and other Rust tooling: "<base>-<arch>-<vendor>-<os>-<libc>".
+	"cargo-dist": {
+		{
+			Pattern: regexp.MustCompile(`^(?P<base>` + reAssetBase + `)-(?P<arch>` + reAssetArch + `)-(?:unknown|apple|pc|linux)-(?P<os>` + reAssetOS + `)(?:-(?P<libc>gnu|musl|msvc|gnullvm|gnueabihf|gnueabi|musleabihf|musleabi|android|androideabi))?` + reAssetSuffix + `?$`),
+		},
+	},
+}
+
+// DefaultNamingRules are used when no naming rules are configured.
+var DefaultNamingRules = namingPresets["default"]
+
+// NamingPreset returns the built-in rules with the given name.
+func NamingPreset(name string) (NamingRules, error) {
+	rules, found := namingPresets[strings.ToLower(name)]
+	if !found {
+		return nil, fmt.Errorf("unknown naming preset %q, must be one of %s", name, strings.Join(NamingPresetNames(), ", "))
+	}
+	return rules, nil
+}
+
+// NamingPresetNames returns the names of the built-in rule sets.
+func NamingPresetNames() []string {
+	out := make([]string, 0, len(namingPresets))
+	for name := range namingPresets {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func parseAssetOS(str string) AssetOS {
+	var value AssetOS
+	if err := value.UnmarshalText([]byte(str)); err != nil {
+		return UnknownAssetOS
+	}
+	return value
+}
+
+func parseAssetArch(str string) AssetArch {
+	var value AssetArch
+	if err := value.UnmarshalText([]byte(str)); err != nil {
+		return UnknownAssetArch
+	}
+	return value
+}
@@ -0,0 +1,89 @@
+package indexfile
+
+import (
+	"testing"
+)
+
+func TestNamingPresets(t *testing.T) {
+	type testRow struct {
+		Preset string
+		Name   string
+		Base   string
+		OS     AssetOS
+		Arch   AssetArch
+		Type   AssetType
+		Libc   string
+	}
+
+	testData := [...]testRow{
+		// The Go distribution itself, and Go tools named after GOOS-GOARCH.
+		{"default", "go1.21.5.linux-amd64.tar.gz", "go", LinuxOS, AMD64Arch, ArchiveType, ""},
+		{"default", "go1.21.5.linux-armv6l.tar.gz", "go", LinuxOS, ARMv6Arch, ArchiveType, ""},
+		{"default", "go1.21.5.darwin-arm64.pkg", "go", DarwinOS, ARM64Arch, PackageType, ""},
+		{"default", "go1.21.5.windows-386.msi", "go", WindowsOS, I386Arch, PackageType, ""},
+		{"default", "go1.21.5.windows-amd64.zip", "go", WindowsOS, AMD64Arch, ArchiveType, ""},
+		{"default", "go1.21.5.src.tar.gz", "", UnknownAssetOS, UnknownAssetArch, ArchiveType, ""},
+		{"default", "tool-linux-amd64", "tool", LinuxOS, AMD64Arch, ExecutableType, ""},
+		{"default", "tool-windows-amd64.exe", "tool", WindowsOS, AMD64Arch, ExecutableType, ""},
+		{"default", "k3s-linux-arm64", "k3s", LinuxOS, ARM64Arch, ExecutableType, ""},
+		{"default", "tool_v1.2.3_darwin_arm64.tar.gz", "tool", DarwinOS, ARM64Arch, ArchiveType, ""},
+		{"default", "SHA256SUMS", "", AnyOS, AnyArch, ChecksumType, ""},
+
+		// Linux packages, which name the arch but not the OS.
+		{"default", "foo_1.0_arm64.deb", "foo", LinuxOS, ARM64Arch, PackageType, ""},
+		{"default", "ripgrep_14.0.3-1_amd64.deb", "ripgrep", LinuxOS, AMD64Arch, PackageType, ""},
+		{"default", "foo_1.0_ppc64el.deb", "foo", LinuxOS, PPC64LEArch, PackageType, ""},
+		{"default", "foo_1.0_all.deb", "foo", LinuxOS, AnyArch, PackageType, ""},
+		{"default", "foo-1.0.x86_64.rpm", "foo", LinuxOS, AMD64Arch, PackageType, ""},
+		{"default", "foo-bar-1.0-1.el8.aarch64.rpm", "foo-bar", LinuxOS, ARM64Arch, PackageType, ""},
+		{"default", "foo-1.0-1.armv7hl.rpm", "foo", LinuxOS, ARMv7Arch, PackageType, ""},
+		{"default", "foo-1.0-1.noarch.rpm", "foo", LinuxOS, AnyArch, PackageType, ""},
+		{"default", "foo-1.0-1.src.rpm", "", UnknownAssetOS, UnknownAssetArch, PackageType, ""},
+		{"default", "foo_1.0_x86_64.apk", "foo", LinuxOS, AMD64Arch, PackageType, ""},
+
+		// GoReleaser, e.g. the GitHub CLI and GoReleaser itself.
+		{"goreleaser", "gh_2.40.1_linux_amd64.tar.gz", "gh", LinuxOS, AMD64Arch, ArchiveType, ""},
+		{"goreleaser", "gh_2.40.1_macOS_arm64.zip", "gh", DarwinOS, ARM64Arch, ArchiveType, ""},
+		{"goreleaser", "gh_2.40.1_windows_386.zip", "gh", WindowsOS, I386Arch, ArchiveType, ""},
+		{"goreleaser", "gh_2.40.1_linux_arm64.deb", "gh", LinuxOS, ARM64Arch, PackageType, ""},
+		{"goreleaser", "gh_2.40.1_linux_amd64.rpm", "gh", LinuxOS, AMD64Arch, PackageType, ""},
+		{"goreleaser", "gh_2.40.1_checksums.txt", "", AnyOS, AnyArch, ChecksumType, ""},
+		{"goreleaser", "goreleaser_Linux_x86_64.tar.gz", "goreleaser", LinuxOS, AMD64Arch, ArchiveType, ""},
+		{"goreleaser", "goreleaser_Linux_armv7.tar.gz", "goreleaser", LinuxOS, ARMv7Arch, ArchiveType, ""},
+		{"goreleaser", "goreleaser_Windows_arm64.zip", "goreleaser", WindowsOS, ARM64Arch, ArchiveType, ""},
+		{"goreleaser", "goreleaser_Darwin_all.tar.gz", "goreleaser", DarwinOS, AnyArch, ArchiveType, ""},
+		{"goreleaser", "goreleaser_1.22.1_amd64.deb", "goreleaser", LinuxOS, AMD64Arch, PackageType, ""},
+		{"goreleaser", "goreleaser-1.22.1-1.x86_64.rpm", "goreleaser", LinuxOS, AMD64Arch, PackageType, ""},
+		{"goreleaser", "goreleaser_1.22.1_x86_64.apk", "goreleaser", LinuxOS, AMD64Arch, PackageType, ""},
+		{"goreleaser", "goreleaser_Linux_x86_64.tar.gz.sbom.json", "goreleaser", LinuxOS, AMD64Arch, SBOMType, ""},
+		{"goreleaser", "checksums.txt", "", AnyOS, AnyArch, ChecksumType, ""},
+		{"goreleaser", "checksums.txt.sig", "", AnyOS, AnyArch, SignatureType, ""},
+		{"goreleaser", "tool-linux-amd64", "", UnknownAssetOS, UnknownAssetArch, UnknownAssetType, ""},
+
+		// cargo-dist, e.g. cargo-dist itself.
+		{"cargo-dist", "cargo-dist-aarch64-apple-darwin.tar.xz", "cargo-dist", DarwinOS, ARM64Arch, ArchiveType, ""},
+		{"cargo-dist", "cargo-dist-x86_64-pc-windows-msvc.zip", "cargo-dist", WindowsOS, AMD64Arch, ArchiveType, "msvc"},
+		{"cargo-dist", "cargo-dist-i686-pc-windows-msvc.zip", "cargo-dist", WindowsOS, I386Arch, ArchiveType, "msvc"},
+		{"cargo-dist", "cargo-dist-x86_64-unknown-linux-gnu.tar.xz", "cargo-dist", LinuxOS, AMD64Arch, ArchiveType, "gnu"},
+		{"cargo-dist", "cargo-dist-x86_64-unknown-linux-musl.tar.xz", "cargo-dist", LinuxOS, AMD64Arch, ArchiveType, "musl"},
+		{"cargo-dist", "cargo-dist-armv7-unknown-linux-gnueabihf.tar.xz", "cargo-dist", LinuxOS, ARMv7Arch, ArchiveType, "gnueabihf"},
+		{"cargo-dist", "cargo-dist-x86_64-unknown-linux-gnu.tar.xz.sha256", "cargo-dist", LinuxOS, AMD64Arch, ChecksumType, "gnu"},
+		{"cargo-dist", "cargo-dist-installer.sh", "", UnknownAssetOS, UnknownAssetArch, UnknownAssetType, ""},
+		{"cargo-dist", "dist-manifest.json", "", UnknownAssetOS, UnknownAssetArch, UnknownAssetType, ""},
+	}
+
+	for _, row := range testData {
+		t.Run(row.Preset+"/"+row.Name, func(t *testing.T) {
+			rules, err := NamingPreset(row.Preset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a := rules.MakeAsset(1, "", row.Name)
+			if a.Base != row.Base || a.OS != row.OS || a.Arch != row.Arch || a.Type != row.Type || a.Libc != row.Libc {
+				t.Errorf("expected base=%q os=%v arch=%v type=%v libc=%q, got base=%q os=%v arch=%v type=%v libc=%q",
+					row.Base, row.OS, row.Arch, row.Type, row.Libc,
+					a.Base, a.OS, a.Arch, a.Type, a.Libc)
+			}
+		})
+	}
+}
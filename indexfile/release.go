@@ -1,12 +1,21 @@
 package indexfile
 
+import (
+	"sort"
+	"time"
+)
+
 type Release struct {
-	ID      int64   `json:"id,omitempty"`
-	Tag     string  `json:"tag"`
-	Name    string  `json:"name,omitempty"`
-	Body    string  `json:"body,omitempty"`
-	Version Version `json:"version"`
-	Assets  []Asset `json:"assets,omitempty"`
+	ID          int64      `json:"id,omitempty"`
+	Tag         string     `json:"tag"`
+	Name        string     `json:"name,omitempty"`
+	Body        string     `json:"body,omitempty"`
+	Prerelease  bool       `json:"prerelease,omitempty"`
+	PublishedAt *time.Time `json:"publishedAt,omitempty"`
+	UpdatedAt   *time.Time `json:"updatedAt,omitempty"`
+	Withdrawn   bool       `json:"withdrawn,omitempty"`
+	Version     Version    `json:"version"`
+	Assets      []Asset    `json:"assets,omitempty"`
 }
 
 func (r Release) CompareTo(other Release) CompareResult {
@@ -20,6 +29,29 @@ func (r Release) CompareTo(other Release) CompareResult {
 	return cmp
 }
 
+// BuildIDs returns the distinct build IDs reported by the release's assets,
+// ordered from most to least common.
+func (r Release) BuildIDs() []string {
+	counts := make(map[string]int, 4)
+	out := make([]string, 0, 4)
+	for _, a := range r.Assets {
+		if a.BuildID == "" {
+			continue
+		}
+		if counts[a.BuildID] == 0 {
+			out = append(out, a.BuildID)
+		}
+		counts[a.BuildID]++
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if counts[out[i]] != counts[out[j]] {
+			return counts[out[i]] > counts[out[j]]
+		}
+		return out[i] < out[j]
+	})
+	return out
+}
+
 func (r Release) FirstMatchingAsset(fn func(Asset) bool) (Asset, bool) {
 	for _, a := range r.Assets {
 		if fn(a) {
@@ -169,7 +169,7 @@ var _ ComparableTo[VersionElementList] = VersionElementList(nil)
 
 var (
 	gCacheMutex sync.Mutex
-	gCacheMap   map[string]VersionElementList
+	gCacheMap   = make(map[string]VersionElementList, 64)
 )
 
 func ParseVersionString(str string) VersionElementList {
@@ -0,0 +1,351 @@
+package indexfile
+
+import (
+	"bytes"
+	"encoding"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// VersionConstraint compares a version against a fixed bound.
+type VersionConstraint struct {
+	Op      string
+	Version Version
+}
+
+func (c VersionConstraint) Match(v Version) bool {
+	cmp := ComparePrecedence(v, c.Version)
+	switch c.Op {
+	case "=":
+		return cmp == EQ
+	case "!=":
+		return cmp != EQ
+	case "<":
+		return cmp == LT
+	case "<=":
+		return cmp != GT
+	case ">":
+		return cmp == GT
+	case ">=":
+		return cmp != LT
+	default:
+		return false
+	}
+}
+
+func (c VersionConstraint) String() string {
+	return c.Op + c.Version.String()
+}
+
+// VersionRange is a set of versions in the syntax used by npm and Cargo: a
+// space-separated list of constraints, all of which must hold, optionally
+// joined by "||" into alternatives, e.g. ">=1.4.0 <3 || =0.9.7".  Besides the
+// usual comparison operators, "^1.2.3" and "~1.2.3" are accepted, and an
+// operator may be followed by whitespace.  As with npm, a partial version is
+// an x-range standing for every version it leaves open: "1.2", "1.2.x" and
+// "1.2.*" all mean ">=1.2.0 <1.3.0-0", "<=1.4" means "<1.5.0-0", ">1.4"
+// means ">=1.5.0", and "*" matches everything.  A hyphen range "A - B"
+// means ">=A <=B", so "1.2.3 - 2.3" is ">=1.2.3 <2.4.0-0".
+type VersionRange [][]VersionConstraint
+
+func ParseVersionRange(str string) (VersionRange, error) {
+	var out VersionRange
+	for _, alt := range strings.Split(str, "||") {
+		fields := strings.Fields(alt)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("failed to parse %q as VersionRange: empty alternative", str)
+		}
+
+		var list []VersionConstraint
+		for index := 0; index < len(fields); index++ {
+			field := fields[index]
+			if isVersionOperator(field) && index+1 < len(fields) {
+				index++
+				field += fields[index]
+			}
+			var constraints []VersionConstraint
+			var err error
+			if index+2 < len(fields) && fields[index+1] == "-" {
+				constraints, err = parseHyphenRange(field, fields[index+2])
+				index += 2
+			} else {
+				constraints, err = parseVersionConstraint(field)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %q as VersionRange: %w", str, err)
+			}
+			list = append(list, constraints...)
+		}
+		out = append(out, list)
+	}
+	return out, nil
+}
+
+var versionOperators = []string{">=", "<=", "!=", ">", "<", "=", "^", "~"}
+
+func isVersionOperator(str string) bool {
+	for _, op := range versionOperators {
+		if str == op {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHyphenRange parses the hyphen range "low - high".  Both ends must be
+// bare versions, without an operator.
+func parseHyphenRange(low string, high string) ([]VersionConstraint, error) {
+	for _, end := range []string{low, high} {
+		if _, _, ok := parsePartialVersion(end); !ok {
+			return nil, fmt.Errorf("invalid hyphen range %q: %q is not a version", low+" - "+high, end)
+		}
+	}
+	lower, err := parseVersionConstraint(">=" + low)
+	if err != nil {
+		return nil, err
+	}
+	upper, err := parseVersionConstraint("<=" + high)
+	if err != nil {
+		return nil, err
+	}
+	return append(lower, upper...), nil
+}
+
+func parseVersionConstraint(str string) ([]VersionConstraint, error) {
+	op := ""
+	for _, candidate := range versionOperators {
+		if strings.HasPrefix(str, candidate) {
+			op = candidate
+			break
+		}
+	}
+
+	v, numParts, ok := parsePartialVersion(str[len(op):])
+	if !ok {
+		return nil, fmt.Errorf("invalid version constraint %q", str)
+	}
+
+	// The bounds of an x-range: lower is the first version it contains, and
+	// upper is the first version beyond it.  An upper bound excludes the
+	// prereleases of that version, too.
+	lower := v
+	var upper Version
+	switch numParts {
+	case 0:
+		upper = Version{}
+	case 1:
+		upper = Version{Major: v.Major + 1, Prerelease: "0"}
+	case 2:
+		upper = Version{Major: v.Major, Minor: v.Minor + 1, Prerelease: "0"}
+	}
+	all := []VersionConstraint{{">=", Version{}}}
+	none := []VersionConstraint{{"<", Version{Prerelease: "0"}}}
+
+	switch op {
+	case "", "=":
+		switch numParts {
+		case 0:
+			return all, nil
+		case 3:
+			return []VersionConstraint{{"=", v}}, nil
+		}
+		return []VersionConstraint{{">=", lower}, {"<", upper}}, nil
+	case "!=":
+		if numParts != 3 {
+			return nil, fmt.Errorf("invalid version constraint %q: %q needs a complete version", str, op)
+		}
+		return []VersionConstraint{{"!=", v}}, nil
+	case ">":
+		switch numParts {
+		case 0:
+			return none, nil
+		case 3:
+			return []VersionConstraint{{">", v}}, nil
+		}
+		upper.Prerelease = ""
+		return []VersionConstraint{{">=", upper}}, nil
+	case ">=":
+		if numParts == 0 {
+			return all, nil
+		}
+		return []VersionConstraint{{">=", lower}}, nil
+	case "<":
+		switch numParts {
+		case 0:
+			return none, nil
+		case 3:
+			return []VersionConstraint{{"<", v}}, nil
+		}
+		lower.Prerelease = "0"
+		return []VersionConstraint{{"<", lower}}, nil
+	case "<=":
+		switch numParts {
+		case 0:
+			return all, nil
+		case 3:
+			return []VersionConstraint{{"<=", v}}, nil
+		}
+		return []VersionConstraint{{"<", upper}}, nil
+	case "^":
+		switch {
+		case numParts == 0:
+			return all, nil
+		case v.Major != 0 || numParts == 1:
+			upper = Version{Major: v.Major + 1, Prerelease: "0"}
+		case v.Minor != 0 || numParts == 2:
+			upper = Version{Minor: v.Minor + 1, Prerelease: "0"}
+		default:
+			upper = Version{Patch: v.Patch + 1, Prerelease: "0"}
+		}
+		return []VersionConstraint{{">=", lower}, {"<", upper}}, nil
+	default: // "~"
+		switch numParts {
+		case 0:
+			return all, nil
+		case 3:
+			upper = Version{Major: v.Major, Minor: v.Minor + 1, Prerelease: "0"}
+		}
+		return []VersionConstraint{{">=", lower}, {"<", upper}}, nil
+	}
+}
+
+// parsePartialVersion parses "1", "1.2", "1.2.3" or "1.2.3-pre", with an
+// optional leading "v".  Any of the numbers may be "x", "X" or "*", which
+// leaves it and the numbers after it unspecified.  It reports how many
+// numbers were specified; the others are zero.
+func parsePartialVersion(str string) (Version, int, bool) {
+	var v Version
+	str = strings.TrimPrefix(str, "v")
+	if i := strings.IndexByte(str, '-'); i >= 0 {
+		v.Prerelease = str[i+1:]
+		str = str[:i]
+		if v.Prerelease == "" {
+			return v, 0, false
+		}
+	}
+
+	parts := strings.Split(str, ".")
+	if len(parts) > 3 {
+		return v, 0, false
+	}
+	numParts := 0
+	for index, part := range parts {
+		switch part {
+		case "x", "X", "*":
+			continue
+		}
+		if numParts != index {
+			return v, 0, false
+		}
+		n, err := strconv.ParseUint(part, 10, 0)
+		if err != nil {
+			return v, 0, false
+		}
+		switch index {
+		case 0:
+			v.Major = uint(n)
+		case 1:
+			v.Minor = uint(n)
+		case 2:
+			v.Patch = uint(n)
+		}
+		numParts++
+	}
+	if v.Prerelease != "" && numParts != 3 {
+		return v, 0, false
+	}
+	return v, numParts, true
+}
+
+// Contains reports whether v satisfies any alternative of the range.  An
+// empty range contains every version.  As with npm, a prerelease only
+// satisfies an alternative that names a prerelease of the same version, so
+// that "<3" does not match "3.0.0-rc1".
+func (r VersionRange) Contains(v Version) bool {
+	if len(r) == 0 {
+		return true
+	}
+	for _, list := range r {
+		ok := true
+		prereleaseOK := v.Prerelease == ""
+		for _, c := range list {
+			if !c.Match(v) {
+				ok = false
+				break
+			}
+			if c.Version.Prerelease != "" && c.Version.Major == v.Major && c.Version.Minor == v.Minor && c.Version.Patch == v.Patch {
+				prereleaseOK = true
+			}
+		}
+		if !prereleaseOK {
+			ok = false
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r VersionRange) String() string {
+	alts := make([]string, len(r))
+	for index, list := range r {
+		parts := make([]string, len(list))
+		for j, c := range list {
+			parts[j] = c.String()
+		}
+		alts[index] = strings.Join(parts, " ")
+	}
+	return strings.Join(alts, " || ")
+}
+
+func (r VersionRange) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *VersionRange) UnmarshalText(raw []byte) error {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		*r = nil
+		return nil
+	}
+	out, err := ParseVersionRange(string(raw))
+	if err != nil {
+		return err
+	}
+	*r = out
+	return nil
+}
+
+// ComparePrecedence compares two versions by Semantic Versioning precedence:
+// unlike Version.CompareTo, a prerelease sorts before the corresponding
+// release, and build IDs are ignored.
+func ComparePrecedence(a Version, b Version) CompareResult {
+	cmp := CompareUint(a.Major, b.Major)
+	if cmp == EQ {
+		cmp = CompareUint(a.Minor, b.Minor)
+	}
+	if cmp == EQ {
+		cmp = CompareUint(a.Patch, b.Patch)
+	}
+	if cmp == EQ {
+		switch {
+		case a.Prerelease == b.Prerelease:
+			// pass
+		case a.Prerelease == "":
+			cmp = GT
+		case b.Prerelease == "":
+			cmp = LT
+		default:
+			cmp = ParseVersionString(a.Prerelease).CompareTo(ParseVersionString(b.Prerelease))
+		}
+	}
+	return cmp
+}
+
+var (
+	_ fmt.Stringer             = VersionRange(nil)
+	_ encoding.TextMarshaler   = VersionRange(nil)
+	_ encoding.TextUnmarshaler = (*VersionRange)(nil)
+)
@@ -0,0 +1,173 @@
+package indexfile
+
+import (
+	"testing"
+)
+
+func TestParseVersionRange(t *testing.T) {
+	type testRow struct {
+		Input    string
+		Expected string
+	}
+
+	testData := [...]testRow{
+		{"1.2.3", "=1.2.3"},
+		{"=1.2.3", "=1.2.3"},
+		{"v1.2.3", "=1.2.3"},
+		{"1.2", ">=1.2.0 <1.3.0-0"},
+		{"1.2.x", ">=1.2.0 <1.3.0-0"},
+		{"1.2.*", ">=1.2.0 <1.3.0-0"},
+		{"1", ">=1.0.0 <2.0.0-0"},
+		{"1.x", ">=1.0.0 <2.0.0-0"},
+		{"*", ">=0.0.0"},
+		{">=1.0", ">=1.0.0"},
+		{">= 1.0", ">=1.0.0"},
+		{">1.4", ">=1.5.0"},
+		{"> 1", ">=2.0.0"},
+		{">1.4.2", ">1.4.2"},
+		{"<1.4", "<1.4.0-0"},
+		{"<1.4.2", "<1.4.2"},
+		{"<=1.4", "<1.5.0-0"},
+		{"<= 1.4.2", "<=1.4.2"},
+		{"!=1.4.2", "!=1.4.2"},
+		{"^1.2.3", ">=1.2.3 <2.0.0-0"},
+		{"^1.2", ">=1.2.0 <2.0.0-0"},
+		{"^1", ">=1.0.0 <2.0.0-0"},
+		{"^0.2.3", ">=0.2.3 <0.3.0-0"},
+		{"^0.2", ">=0.2.0 <0.3.0-0"},
+		{"^0.0.3", ">=0.0.3 <0.0.4-0"},
+		{"^0.0", ">=0.0.0 <0.1.0-0"},
+		{"^0", ">=0.0.0 <1.0.0-0"},
+		{"^1.2.3-beta.2", ">=1.2.3-beta.2 <2.0.0-0"},
+		{"^ 1.2.3", ">=1.2.3 <2.0.0-0"},
+		{"~1.2.3", ">=1.2.3 <1.3.0-0"},
+		{"~1.2", ">=1.2.0 <1.3.0-0"},
+		{"~1", ">=1.0.0 <2.0.0-0"},
+		{"~0.2.3", ">=0.2.3 <0.3.0-0"},
+		{">=1.4.0 <3", ">=1.4.0 <3.0.0-0"},
+		{"^1.2 || ~0.9.7", ">=1.2.0 <2.0.0-0 || >=0.9.7 <0.10.0-0"},
+		{">= 1.0 < 2 || = 3.0.0", ">=1.0.0 <2.0.0-0 || =3.0.0"},
+		{"1.2.3 - 2.3.4", ">=1.2.3 <=2.3.4"},
+		{"1.2 - 2.3.4", ">=1.2.0 <=2.3.4"},
+		{"1.2.3 - 2.3", ">=1.2.3 <2.4.0-0"},
+		{"1.2.3 - 2", ">=1.2.3 <3.0.0-0"},
+		{"v1.2.3-rc1 - v2", ">=1.2.3-rc1 <3.0.0-0"},
+		{"* - 2", ">=0.0.0 <3.0.0-0"},
+		{"1.0.0 - 1.4 || ^3", ">=1.0.0 <1.5.0-0 || >=3.0.0 <4.0.0-0"},
+	}
+
+	for _, row := range testData {
+		t.Run(row.Input, func(t *testing.T) {
+			r, err := ParseVersionRange(row.Input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual := r.String(); actual != row.Expected {
+				t.Errorf("expected %q, got %q", row.Expected, actual)
+			}
+
+			// String() must round-trip.
+			r2, err := ParseVersionRange(r.String())
+			if err != nil {
+				t.Fatalf("failed to parse %q again: %v", r.String(), err)
+			}
+			if actual := r2.String(); actual != row.Expected {
+				t.Errorf("round trip: expected %q, got %q", row.Expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseVersionRange_Invalid(t *testing.T) {
+	testData := [...]string{
+		"",
+		"||",
+		"1.2.3 ||",
+		"1.2.3.4",
+		"1.x.3",
+		"1.2-beta",
+		"1.2.3-",
+		"foo",
+		">=",
+		"!=1.2",
+		">=1.0 <",
+		"1.2.3 -",
+		"- 2.0.0",
+		"1.2.3 - - 2.0.0",
+		">=1.2.3 - 2.0.0",
+		"1.2.3 - <2.0.0",
+	}
+
+	for _, input := range testData {
+		t.Run(input, func(t *testing.T) {
+			r, err := ParseVersionRange(input)
+			if err == nil {
+				t.Errorf("expected an error, got %q", r.String())
+			}
+		})
+	}
+}
+
+func TestVersionRange_Contains(t *testing.T) {
+	type testRow struct {
+		Range    string
+		Version  string
+		Expected bool
+	}
+
+	testData := [...]testRow{
+		{"1.2", "v1.2.0", true},
+		{"1.2", "v1.2.9", true},
+		{"1.2", "v1.3.0", false},
+		{"1.2", "v1.1.9", false},
+		{"<=1.4", "v1.4.7", true},
+		{"<=1.4", "v1.5.0", false},
+		{"<1.4", "v1.3.9", true},
+		{"<1.4", "v1.4.0", false},
+		{">1.4", "v1.4.7", false},
+		{">1.4", "v1.5.0", true},
+		{">= 1.0", "v1.0.0", true},
+		{">= 1.0", "v0.9.9", false},
+		{"*", "v0.0.1", true},
+		{"*", "v1.0.0-rc1", false},
+		{"^1.2.3", "v1.9.9", true},
+		{"^1.2.3", "v2.0.0", false},
+		{"^1.2.3", "v1.2.2", false},
+		{"^0.2.3", "v0.2.9", true},
+		{"^0.2.3", "v0.3.0", false},
+		{"^0.0.3", "v0.0.3", true},
+		{"^0.0.3", "v0.0.4", false},
+		{"~1.2.3", "v1.2.9", true},
+		{"~1.2.3", "v1.3.0", false},
+		{"~1", "v1.9.0", true},
+		{"~1", "v2.0.0", false},
+		{"^1.2 || ^3", "v3.1.0", true},
+		{"^1.2 || ^3", "v2.1.0", false},
+		{"<3", "v3.0.0-rc1", false},
+		{"<3", "v2.9.9-rc1", false},
+		{"^1.2.3-beta.2", "v1.2.3-beta.3", true},
+		{"^1.2.3-beta.2", "v1.2.3-beta.1", false},
+		{"^1.2.3-beta.2", "v1.2.4-beta.1", false},
+		{"^1.2.3-beta.2", "v1.2.3", true},
+		{"^1.2", "v2.0.0-rc1", false},
+		{"1.2.3 - 2.3", "v2.3.9", true},
+		{"1.2.3 - 2.3", "v2.4.0", false},
+		{"1.2.3 - 2.3", "v1.2.2", false},
+	}
+
+	for _, row := range testData {
+		t.Run(row.Range+"/"+row.Version, func(t *testing.T) {
+			r, err := ParseVersionRange(row.Range)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var v Version
+			if !v.Parse(row.Version) {
+				t.Fatalf("failed to parse version %q", row.Version)
+			}
+			if actual := r.Contains(v); actual != row.Expected {
+				t.Errorf("expected %v, got %v", row.Expected, actual)
+			}
+		})
+	}
+}
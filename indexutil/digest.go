@@ -0,0 +1,67 @@
+package indexutil
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"io"
+	"os"
+)
+
+type Digester struct {
+	size   int64
+	sha256 hash.Hash
+	sha512 hash.Hash
+}
+
+func NewDigester(withSHA512 bool) *Digester {
+	d := &Digester{sha256: sha256.New()}
+	if withSHA512 {
+		d.sha512 = sha512.New()
+	}
+	return d
+}
+
+func (d *Digester) Write(p []byte) (int, error) {
+	d.size += int64(len(p))
+	_, _ = d.sha256.Write(p)
+	if d.sha512 != nil {
+		_, _ = d.sha512.Write(p)
+	}
+	return len(p), nil
+}
+
+func (d *Digester) Size() int64 {
+	return d.size
+}
+
+func (d *Digester) SHA256() string {
+	return hex.EncodeToString(d.sha256.Sum(nil))
+}
+
+func (d *Digester) SHA512() string {
+	if d.sha512 == nil {
+		return ""
+	}
+	return hex.EncodeToString(d.sha512.Sum(nil))
+}
+
+func DigestFile(filePath string, withSHA512 bool) (*Digester, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	d := NewDigester(withSHA512)
+	_, err = io.Copy(d, file)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+var _ io.Writer = (*Digester)(nil)
@@ -8,7 +8,7 @@ import (
 	"github.com/rs/zerolog"
 )
 
-func FromJSON[T any](ctx context.Context, ptr *T, raw []byte) {
+func FromJSON[T any](ctx context.Context, ptr *T, raw []byte) error {
 	var tmp T
 	d := json.NewDecoder(bytes.NewReader(raw))
 	d.UseNumber()
@@ -16,12 +16,13 @@ func FromJSON[T any](ctx context.Context, ptr *T, raw []byte) {
 	err := d.Decode(&tmp)
 	if err != nil {
 		logger := zerolog.Ctx(ctx)
-		logger.Fatal().
+		logger.Error().
 			Err(err).
 			Msgf("failed to decode JSON as value of type %T", tmp)
-		panic(nil)
+		return err
 	}
 	*ptr = tmp
+	return nil
 }
 
 func ToJSON(ctx context.Context, value any) []byte {
@@ -1,8 +1,10 @@
 package indexutil
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -10,7 +12,12 @@ import (
 	"github.com/rs/zerolog"
 )
 
-func WriteFile(ctx context.Context, filePath string, contents []byte, mode fs.FileMode) {
+func WriteFile(ctx context.Context, filePath string, contents []byte, mode fs.FileMode) error {
+	_, err := WriteFileFrom(ctx, filePath, bytes.NewReader(contents), mode)
+	return err
+}
+
+func WriteFileFrom(ctx context.Context, filePath string, r io.Reader, mode fs.FileMode) (int64, error) {
 	logger := zerolog.Ctx(ctx)
 
 	filePath = filepath.Clean(filePath)
@@ -26,21 +33,21 @@ func WriteFile(ctx context.Context, filePath string, contents []byte, mode fs.Fi
 
 	err := os.MkdirAll(dirPath, dirMode)
 	if err != nil {
-		logger.Fatal().
+		logger.Error().
 			Str("path", dirPath).
 			Str("mode", dirModeName).
 			Err(err).
 			Msg("failed to create parent directory")
-		panic(nil)
+		return 0, err
 	}
 
 	dir, err := os.OpenFile(dirPath, os.O_RDONLY, 0)
 	if err != nil {
-		logger.Fatal().
+		logger.Error().
 			Str("path", dirPath).
 			Err(err).
 			Msg("failed to open parent directory for metadata sync")
-		panic(nil)
+		return 0, err
 	}
 
 	needDirClose := true
@@ -54,12 +61,12 @@ func WriteFile(ctx context.Context, filePath string, contents []byte, mode fs.Fi
 
 	file, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
 	if err != nil {
-		logger.Fatal().
+		logger.Error().
 			Str("path", tempPath).
 			Str("mode", modeName).
 			Err(err).
 			Msg("failed to create temporary file")
-		panic(nil)
+		return 0, err
 	}
 
 	needFileClose := true
@@ -73,61 +80,123 @@ func WriteFile(ctx context.Context, filePath string, contents []byte, mode fs.Fi
 		}
 	}()
 
-	_, err = file.Write(contents)
+	n, err := io.Copy(file, r)
 	if err != nil {
-		logger.Fatal().
+		logger.Error().
 			Str("path", tempPath).
+			Int64("bytesWritten", n).
 			Err(err).
 			Msg("I/O error while writing to temporary file")
-		panic(nil)
+		return n, err
 	}
 
 	err = file.Sync()
 	if err != nil {
-		logger.Fatal().
+		logger.Error().
 			Str("path", tempPath).
 			Err(err).
 			Msg("I/O error while syncing file data to disk")
-		panic(nil)
+		return 0, err
 	}
 
 	needFileClose = false
 	err = file.Close()
 	if err != nil {
-		logger.Fatal().
+		logger.Error().
 			Str("path", tempPath).
 			Err(err).
 			Msg("I/O error while closing file")
-		panic(nil)
+		return 0, err
 	}
 
 	err = os.Rename(tempPath, filePath)
 	if err != nil {
-		logger.Fatal().
+		logger.Error().
 			Str("old", tempPath).
 			Str("new", filePath).
 			Err(err).
 			Msg("failed to rename file to permanent filename")
-		panic(nil)
+		return 0, err
 	}
 
 	needFileRemove = false
 	err = dir.Sync()
 	if err != nil {
-		logger.Fatal().
+		logger.Error().
 			Str("path", dirPath).
 			Err(err).
 			Msg("I/O error while syncing file metadata to disk")
-		panic(nil)
+		return 0, err
 	}
 
 	needDirClose = false
 	err = dir.Close()
 	if err != nil {
-		logger.Fatal().
+		logger.Error().
+			Str("path", dirPath).
+			Err(err).
+			Msg("I/O error while closing parent directory")
+		return 0, err
+	}
+	return n, nil
+}
+
+func RenameFile(ctx context.Context, oldPath string, newPath string, mode fs.FileMode) error {
+	logger := zerolog.Ctx(ctx)
+
+	newPath = filepath.Clean(newPath)
+	dirPath := filepath.Dir(newPath)
+
+	// Preserve "r" and "w" bits, and set "x" bit to equal "r" bit.
+	dirMode := (0o666 & mode) | ((0o444 & mode) >> 2)
+	dirModeName := fmt.Sprintf("%03o", dirMode)
+
+	err := os.MkdirAll(dirPath, dirMode)
+	if err != nil {
+		logger.Error().
+			Str("path", dirPath).
+			Str("mode", dirModeName).
+			Err(err).
+			Msg("failed to create parent directory")
+		return err
+	}
+
+	err = os.Rename(oldPath, newPath)
+	if err != nil {
+		logger.Error().
+			Str("old", oldPath).
+			Str("new", newPath).
+			Err(err).
+			Msg("failed to rename file to permanent filename")
+		return err
+	}
+
+	dir, err := os.OpenFile(dirPath, os.O_RDONLY, 0)
+	if err != nil {
+		logger.Error().
+			Str("path", dirPath).
+			Err(err).
+			Msg("failed to open parent directory for metadata sync")
+		return err
+	}
+
+	err = dir.Sync()
+	if err != nil {
+		_ = dir.Close()
+		logger.Error().
+			Str("path", dirPath).
+			Err(err).
+			Msg("I/O error while syncing file metadata to disk")
+		return err
+	}
+
+	err = dir.Close()
+	if err != nil {
+		logger.Error().
 			Str("path", dirPath).
 			Err(err).
 			Msg("I/O error while closing parent directory")
-		panic(nil)
+		return err
 	}
+	return nil
 }
@@ -8,19 +8,20 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-func FromYAML[T any](ctx context.Context, ptr *T, raw []byte) {
+func FromYAML[T any](ctx context.Context, ptr *T, raw []byte) error {
 	var tmp T
 	d := yaml.NewDecoder(bytes.NewReader(raw))
 	d.KnownFields(true)
 	err := d.Decode(&tmp)
 	if err != nil {
 		logger := zerolog.Ctx(ctx)
-		logger.Fatal().
+		logger.Error().
 			Err(err).
 			Msgf("failed to decode YAML as value of type %T", tmp)
-		panic(nil)
+		return err
 	}
 	*ptr = tmp
+	return nil
 }
 
 func ToYAML(ctx context.Context, value any) []byte {
@@ -1,21 +1,17 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"io"
-	"io/fs"
 	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
+	"strings"
 
-	"github.com/google/go-github/v48/github"
 	"github.com/pborman/getopt/v2"
 	"github.com/rs/zerolog"
 
-	"github.com/chronos-tachyon/github-asset-mirror/indexfile"
 	"github.com/chronos-tachyon/github-asset-mirror/indexutil"
 	"github.com/chronos-tachyon/github-asset-mirror/logging"
 )
@@ -26,13 +22,18 @@ var (
 
 const (
 	UserAgentFormat = "github-asset-mirror/%s (+https://github.com/chronos-tachyon/github-asset-mirror)"
-	ReleasesPerPage = 10
-	AssetsPerPage   = 10
+	ReleasesPerPage = 100
+	AssetsPerPage   = 100
 )
 
+// MyRoundTripper sets the User-Agent of every request, and adds the GitHub
+// token from Source to requests bound for one of Hosts.  Requests to any
+// other host, such as the storage hosts that asset downloads redirect to,
+// never see it.
 type MyRoundTripper struct {
-	Next  http.RoundTripper
-	Token string
+	Next   http.RoundTripper
+	Source TokenSource
+	Hosts  []string
 }
 
 func UserAgent() string {
@@ -42,7 +43,15 @@ func UserAgent() string {
 func (rt *MyRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	header := make(http.Header, 4+len(req.Header))
 	header.Set("user-agent", UserAgent())
-	header.Set("authorization", "Bearer "+rt.Token)
+	if rt.Source != nil && rt.Authorizes(req.URL) {
+		token, err := rt.Source.Token(req.Context())
+		if err != nil {
+			return nil, err
+		}
+		if token != "" {
+			header.Set("authorization", "Bearer "+token)
+		}
+	}
 	for key, values := range req.Header {
 		header[key] = values
 	}
@@ -52,6 +61,30 @@ func (rt *MyRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	return rt.Next.RoundTrip(req)
 }
 
+// Authorizes reports whether the token may be sent to u.  Hosts listed
+// without a port match any port.
+func (rt *MyRoundTripper) Authorizes(u *url.URL) bool {
+	for _, host := range rt.Hosts {
+		if strings.EqualFold(host, u.Host) || strings.EqualFold(host, u.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// StripAuthOnRedirect is an http.Client CheckRedirect function that drops
+// credentials set on the original request whenever a redirect leaves its
+// host.
+func StripAuthOnRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if !strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+		req.Header.Del("authorization")
+	}
+	return nil
+}
+
 func main() {
 	logging.Init()
 	defer logging.Done()
@@ -59,257 +92,294 @@ func main() {
 	ctx := context.Background()
 	logger := zerolog.Ctx(ctx)
 
+	var configFile string
 	var tokenFile string
+	var githubURL string
+	var uploadURL string
+	var caBundle string
+	var appID int64
+	var appInstallationID int64
+	var appPrivateKeyFile string
+	var tokenCommand string
+	var anonymous bool
 	var ghOwner string
 	var ghRepo string
 	var outputDir string
-
+	var jobs int
+	var maxAttempts int
+	var minRemaining int
+	var withSHA512 bool
+	var extractArchives bool
+	var apply bool
+	var repair bool
+
+	getopt.FlagLong(&configFile, "config", 'c', "path to YAML config file listing the GitHub repositories to mirror")
 	getopt.FlagLong(&tokenFile, "token-file", 'T', "path to file containing your GitHub token")
+	getopt.FlagLong(&githubURL, "github-url", 0, "API base URL of a GitHub Enterprise Server instance")
+	getopt.FlagLong(&uploadURL, "upload-url", 0, "upload URL of a GitHub Enterprise Server instance")
+	getopt.FlagLong(&caBundle, "ca-bundle", 0, "path to a PEM file of extra CA certificates to trust")
+	getopt.FlagLong(&tokenCommand, "token-command", 0, "command that prints your GitHub token, e.g. a credential helper; split on whitespace, without quoting")
+	getopt.FlagLong(&anonymous, "anonymous", 0, "access GitHub without credentials")
+	getopt.FlagLong(&appID, "app-id", 0, "authenticate as the GitHub App with this ID instead of using a token")
+	getopt.FlagLong(&appInstallationID, "app-installation-id", 0, "GitHub App installation ID; looked up from the repository if omitted")
+	getopt.FlagLong(&appPrivateKeyFile, "app-private-key", 0, "path to the GitHub App's PEM-encoded private key")
 	getopt.FlagLong(&ghOwner, "github-owner", 'O', "name of GitHub repository's owner user or owner organization")
 	getopt.FlagLong(&ghRepo, "github-repo", 'R', "name of GitHub repository")
 	getopt.FlagLong(&outputDir, "output-dir", 'd', "path to the output directory")
+	getopt.FlagLong(&jobs, "jobs", 'j', "maximum number of assets to download in parallel")
+	getopt.FlagLong(&maxAttempts, "max-attempts", 0, "maximum number of attempts for each GitHub API call or download")
+	getopt.FlagLong(&minRemaining, "min-rate-limit-remaining", 0, "stop early once fewer than this many GitHub API requests remain")
+	getopt.FlagLong(&withSHA512, "sha512", 0, "also record the SHA-512 digest of each asset")
+	getopt.FlagLong(&extractArchives, "extract-archives", 0, "unpack archive assets and record their contents")
+	getopt.FlagLong(&apply, "apply", 0, "gc: actually remove the garbage found, rather than only reporting it")
+	getopt.FlagLong(&repair, "repair", 0, "verify: download missing or damaged files again")
+	getopt.SetParameters("[mirror | gc | verify]")
 	getopt.Parse()
 
-	if tokenFile == "" {
-		logger.Fatal().Msg("missing required flag -T / --token-file")
+	command := "mirror"
+	if args := getopt.Args(); len(args) != 0 {
+		command = args[0]
+
+		// Flags may also follow the command.
+		getopt.CommandLine.Parse(args)
+		if extra := getopt.Args(); len(extra) != 0 {
+			logger.Fatal().
+				Strs("args", extra).
+				Msg("unexpected command line arguments")
+		}
+	}
+	switch command {
+	case "mirror", "gc", "verify":
+		// pass
+	default:
+		logger.Fatal().
+			Str("command", command).
+			Msg("unknown command; expected \"mirror\", \"gc\" or \"verify\"")
+	}
+
+	var cfg Config
+	switch {
+	case configFile != "":
+		if ghOwner != "" || ghRepo != "" {
+			logger.Fatal().
+				Msg("flags -O / --github-owner and -R / --github-repo cannot be combined with -c / --config")
+		}
+
+		var err error
+		cfg, err = LoadConfig(ctx, configFile)
+		if err != nil {
+			logger.Fatal().
+				Str("configFile", configFile).
+				Err(err).
+				Msg("failed to load config file")
+		}
+
+	default:
+		if ghOwner == "" {
+			logger.Fatal().Msg("missing required flag -O / --github-owner")
+		}
+		if ghRepo == "" {
+			logger.Fatal().Msg("missing required flag -R / --github-repo")
+		}
+		if outputDir == "" {
+			logger.Fatal().Msg("missing required flag -d / --output-dir")
+		}
+
+		cfg.Repos = []RepoConfig{{Owner: ghOwner, Repo: ghRepo, OutputDir: "."}}
+	}
+
+	// Credentials given on the command line replace those in the config,
+	// including any in the hosts entry for the top-level githubURL.
+	credentialFlags := true
+	switch {
+	case tokenFile != "":
+		cfg.TokenFile, cfg.App, cfg.TokenCommand, cfg.Anonymous = tokenFile, nil, nil, false
+	case appID != 0:
+		app := &AppConfig{
+			AppID:          appID,
+			InstallationID: appInstallationID,
+			PrivateKeyFile: appPrivateKeyFile,
+		}
+		cfg.TokenFile, cfg.App, cfg.TokenCommand, cfg.Anonymous = "", app, nil, false
+	case tokenCommand != "":
+		// No shell quoting is supported; commands whose arguments
+		// contain spaces belong in the config's tokenCommand list.
+		cfg.TokenFile, cfg.App, cfg.TokenCommand, cfg.Anonymous = "", nil, strings.Fields(tokenCommand), false
+	case anonymous:
+		cfg.TokenFile, cfg.App, cfg.TokenCommand, cfg.Anonymous = "", nil, nil, true
+	default:
+		credentialFlags = false
+	}
+	if githubURL != "" {
+		cfg.GitHubURL = githubURL
+	}
+	if uploadURL != "" {
+		cfg.UploadURL = uploadURL
 	}
-	if ghOwner == "" {
-		logger.Fatal().Msg("missing required flag -O / --github-owner")
+	if caBundle != "" {
+		cfg.CABundle = caBundle
 	}
-	if ghRepo == "" {
-		logger.Fatal().Msg("missing required flag -R / --github-repo")
+	if outputDir != "" {
+		cfg.OutputDir = outputDir
 	}
-	if outputDir == "" {
-		logger.Fatal().Msg("missing required flag -d / --output-dir")
+	if jobs != 0 {
+		cfg.Jobs = jobs
+	}
+	if maxAttempts != 0 {
+		cfg.Retry.MaxAttempts = maxAttempts
+	}
+	if minRemaining != 0 {
+		cfg.RateLimit.MinRemaining = minRemaining
+	}
+	if withSHA512 {
+		cfg.SHA512 = true
+	}
+	if extractArchives {
+		cfg.ExtractArchives = true
+	}
+	if credentialFlags {
+		hostName := HostName(cfg.RepoGitHubURL(&RepoConfig{}))
+		if host, found := cfg.Hosts[hostName]; found {
+			host.TokenFile, host.App, host.TokenCommand, host.Anonymous = "", nil, nil, false
+			cfg.Hosts[hostName] = host
+		}
 	}
 
-	raw, err := os.ReadFile(tokenFile)
+	err := cfg.Validate()
 	if err != nil {
 		logger.Fatal().
-			Str("tokenFile", tokenFile).
+			Str("configFile", configFile).
 			Err(err).
-			Msg("failed to read GitHub access token from file")
-		panic(nil)
+			Msg("invalid configuration")
+	}
+
+	switch command {
+	case "gc":
+		collectAllGarbage(ctx, &cfg, apply)
+	case "verify":
+		verifyAll(ctx, &cfg, repair)
+	default:
+		mirrorAll(ctx, &cfg)
 	}
-	raw = bytes.TrimSpace(raw)
-	accessToken := string(raw)
+}
 
-	releases := make([]indexfile.Release, 0, 256)
+func mirrorAll(ctx context.Context, cfg *Config) {
+	logger := zerolog.Ctx(ctx)
 
-	indexFilePath := filepath.Join(outputDir, indexfile.IndexFileName)
-	indexLogger := logger.With().
-		Str("path", indexFilePath).
-		Logger()
+	failed := 0
+	errs := make([]error, len(cfg.Repos))
+	for index := range cfg.Repos {
+		repo := &cfg.Repos[index]
+		errs[index] = MirrorRepo(ctx, cfg, repo)
+		if errs[index] != nil && !errors.Is(errs[index], ErrRateLimitBudget) {
+			failed++
+		}
+	}
 
-	raw, err = os.ReadFile(indexFilePath)
-	if err != nil && !errors.Is(err, fs.ErrNotExist) {
-		indexLogger.Fatal().
-			Err(err).
-			Msg("failed to read contents of JSON index file")
-		panic(nil)
+	for index := range cfg.Repos {
+		repo := &cfg.Repos[index]
+		repoLogger := logger.With().
+			Str("githubOwner", repo.Owner).
+			Str("githubRepo", repo.Repo).
+			Str("outputDir", cfg.RepoOutputDir(repo)).
+			Logger()
+		if err := errs[index]; errors.Is(err, ErrRateLimitBudget) {
+			repoLogger.Warn().
+				Err(err).
+				Msg("stopped early to preserve GitHub API rate limit; rerun later to resume")
+		} else if err != nil {
+			repoLogger.Error().
+				Err(err).
+				Msg("failed to mirror GitHub repository")
+		} else {
+			repoLogger.Info().
+				Msg("mirrored GitHub repository")
+		}
 	}
-	if err == nil {
-		ctx2 := indexLogger.WithContext(ctx)
-		indexutil.FromJSON(ctx2, &releases, raw)
+
+	if failed != 0 {
+		logger.Fatal().
+			Int("numFailed", failed).
+			Int("numTotal", len(cfg.Repos)).
+			Msg("failed to mirror one or more GitHub repositories")
 	}
+}
+
+func collectAllGarbage(ctx context.Context, cfg *Config, apply bool) {
+	logger := zerolog.Ctx(ctx)
 
-	releaseIndexByTag := make(map[string]uint, 256)
-	for index, release := range releases {
-		releaseIndexByTag[release.Tag] = uint(index)
+	failed := 0
+	total := 0
+	for index := range cfg.Repos {
+		repo := &cfg.Repos[index]
+		n, err := CollectGarbage(ctx, cfg, repo, apply)
+		total += n
+		if err != nil {
+			failed++
+		}
 	}
 
-	var rt http.RoundTripper = http.DefaultClient.Transport
-	if rt == nil {
-		rt = http.DefaultTransport
+	switch {
+	case failed != 0:
+		logger.Fatal().
+			Int("numFailed", failed).
+			Int("numTotal", len(cfg.Repos)).
+			Msg("failed to collect garbage in one or more output directories")
+	case total != 0 && !apply:
+		logger.Info().
+			Int("numFound", total).
+			Msg("found garbage; rerun with --apply to remove it")
+	default:
+		logger.Info().
+			Int("numRemoved", total).
+			Msg("collected garbage")
 	}
-	rt = &MyRoundTripper{Next: rt, Token: accessToken}
-	http.DefaultClient.Transport = rt
-	client := github.NewClient(http.DefaultClient)
-
-	ghLogger := logger.With().
-		Str("githubOwner", ghOwner).
-		Str("githubRepo", ghRepo).
-		Logger()
-
-	Iterate(
-		ReleasesPerPage,
-		func(options *github.ListOptions) ([]*github.RepositoryRelease, *github.Response) {
-			list, resp, err := client.Repositories.ListReleases(ctx, ghOwner, ghRepo, options)
-			if err == nil {
-				return list, resp
-			}
-			ghLogger.Fatal().
-				Int("pageNumber", options.Page).
+}
+
+func verifyAll(ctx context.Context, cfg *Config, repair bool) {
+	logger := zerolog.Ctx(ctx)
+
+	failed := 0
+	report := VerifyReport{Problems: make([]VerifyProblem, 0, 16)}
+	for index := range cfg.Repos {
+		repo := &cfg.Repos[index]
+		numFiles, problems, err := VerifyRepo(ctx, cfg, repo, repair)
+		report.NumFiles += numFiles
+		report.Problems = append(report.Problems, problems...)
+		if err != nil {
+			logger.Error().
+				Str("githubOwner", repo.Owner).
+				Str("githubRepo", repo.Repo).
 				Err(err).
-				Msg("failed to list GitHub releases")
-			panic(nil)
-		},
-		func(ghr *github.RepositoryRelease) {
-			if ghr.GetDraft() {
-				return
-			}
-
-			id := ghr.GetID()
-			tag := ghr.GetTagName()
-
-			ghrLogger := ghLogger.With().
-				Int64("releaseID", id).
-				Str("releaseTag", tag).
-				Logger()
-
-			var release indexfile.Release
-			releaseIndex, found := releaseIndexByTag[tag]
-			switch {
-			case found:
-				release = releases[releaseIndex]
-			default:
-				release.Tag = tag
-				if !release.Version.Parse(tag) {
-					ghrLogger.Error().
-						Msg("failed to parse GitHub release tag as a semantic version")
-					return
-				}
-			}
-
-			release.ID = id
-			release.Name = ghr.GetName()
-			release.Body = ghr.GetBody()
-			release.Assets = make([]indexfile.Asset, 2, 16)
-			release.Assets[0] = indexfile.MakeSourceTarballAsset(ghr.GetTarballURL())
-			release.Assets[1] = indexfile.MakeSourceZipballAsset(ghr.GetZipballURL())
-
-			Iterate(
-				AssetsPerPage,
-				func(options *github.ListOptions) ([]*github.ReleaseAsset, *github.Response) {
-					list, resp, err := client.Repositories.ListReleaseAssets(ctx, ghOwner, ghRepo, id, options)
-					if err == nil {
-						return list, resp
-					}
-					ghrLogger.Fatal().
-						Int("pageNumber", options.Page).
-						Err(err).
-						Msg("failed to list assets for GitHub release")
-					panic(nil)
-				},
-				func(gha *github.ReleaseAsset) {
-					release.Assets = append(
-						release.Assets,
-						indexfile.MakeAsset(
-							gha.GetID(),
-							gha.GetBrowserDownloadURL(),
-							gha.GetName(),
-						),
-					)
-				},
-			)
-
-			type AssetList = indexfile.SortableList[indexfile.Asset]
-			AssetList(release.Assets).Sort()
-
-			switch {
-			case found:
-				releases[releaseIndex] = release
-			default:
-				releaseIndex = uint(len(releases))
-				releases = append(releases, release)
-				releaseIndexByTag[tag] = releaseIndex
-			}
-		},
-	)
-
-	type ReleaseList = indexfile.SortableList[indexfile.Release]
-	ReleaseList(releases).Sort()
-
-	for _, release := range releases {
-		for _, asset := range release.Assets {
-			assetPath := filepath.Join(outputDir, release.Tag, asset.Name)
-
-			assetLogger := logger.With().
-				Int64("releaseID", release.ID).
-				Str("releaseTag", release.Tag).
-				Int64("assetID", asset.ID).
-				Str("assetURL", asset.URL).
-				Str("assetPath", assetPath).
-				Logger()
-
-			_, err := os.Stat(assetPath)
-			if err == nil {
-				continue
-			}
-			if !errors.Is(err, fs.ErrNotExist) {
-				assetLogger.Fatal().
-					Err(err).
-					Msg("failed to stat file containing downloaded asset")
-				panic(nil)
-			}
-
-			assetLogger.Info().
-				Msg("downloading asset to local file")
-
-			reqURL := asset.URL
-			reqMethod := http.MethodGet
-
-			req, err := http.NewRequestWithContext(ctx, reqMethod, reqURL, http.NoBody)
-			if err != nil {
-				assetLogger.Fatal().
-					Err(err).
-					Msg("failed to create HTTP request object")
-				panic(nil)
-			}
-
-			resp, err := http.DefaultClient.Do(req)
-			if err != nil {
-				assetLogger.Fatal().
-					Err(err).
-					Msg("HTTP request failed")
-				panic(nil)
-			}
-
-			assetLogger = assetLogger.With().
-				Int("statusCode", resp.StatusCode).
-				Logger()
-
-			if resp.StatusCode != http.StatusOK {
-				_ = resp.Body.Close()
-				assetLogger.Fatal().
-					Msg("unexpected HTTP status code")
-				panic(nil)
-			}
-
-			raw, err := io.ReadAll(resp.Body)
-			if err2 := resp.Body.Close(); err == nil {
-				err = err2
-			}
-			if err != nil {
-				assetLogger.Fatal().
-					Err(err).
-					Msg("I/O error while reading HTTP response body")
-				panic(nil)
-			}
-
-			ctx2 := assetLogger.WithContext(ctx)
-			indexutil.WriteFile(ctx2, assetPath, raw, asset.Mode())
+				Msg("failed to verify mirror")
+			failed++
 		}
 	}
 
-	for _, release := range releases {
-		releaseDir := filepath.Join(outputDir, release.Tag)
-		for _, asset := range release.Assets {
-			if asset.Type == indexfile.ExecutableType && release.Version.BuildID == "" {
-				if buildID, ok := asset.ExtractBuildID(ctx, releaseDir); ok {
-					release.Version.BuildID = buildID
-				}
-			}
+	_, _ = os.Stdout.Write(indexutil.ToJSON(ctx, report))
+
+	numUnrepaired := 0
+	for _, problem := range report.Problems {
+		if !problem.Repaired {
+			numUnrepaired++
 		}
 	}
 
-	ctx2 := indexLogger.WithContext(ctx)
-	raw = indexutil.ToJSON(ctx2, releases)
-	indexutil.WriteFile(ctx2, indexFilePath, raw, 0o666)
-	if err != nil {
-		indexLogger.Fatal().
-			Err(err).
-			Msgf("failed to write contents of new JSON index file")
-		panic(nil)
+	switch {
+	case failed != 0:
+		logger.Fatal().
+			Int("numFailed", failed).
+			Int("numTotal", len(cfg.Repos)).
+			Msg("failed to verify one or more mirrors")
+	case numUnrepaired != 0:
+		logger.Fatal().
+			Int("numFiles", report.NumFiles).
+			Int("numProblems", numUnrepaired).
+			Msg("mirror failed verification")
+	default:
+		logger.Info().
+			Int("numFiles", report.NumFiles).
+			Int("numRepaired", len(report.Problems)).
+			Msg("mirror verified")
 	}
 }
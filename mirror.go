@@ -0,0 +1,485 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/go-github/v48/github"
+	"github.com/rs/zerolog"
+
+	"github.com/chronos-tachyon/github-asset-mirror/indexfile"
+	"github.com/chronos-tachyon/github-asset-mirror/indexutil"
+)
+
+type Mirror struct {
+	Owner     string
+	Repo      string
+	OutputDir string
+	Client    *github.Client
+	HTTP      *http.Client
+	Storage   *http.Client
+
+	Filters            ReleaseFilters
+	Jobs               int
+	Retry              RetryPolicy
+	RateLimit          RateLimitPolicy
+	SHA512             bool
+	ExtractArchives    bool
+	OnChecksumMismatch string
+	ProvenanceKey      crypto.PublicKey
+	Naming             indexfile.NamingRules
+	Retention          RetentionPolicy
+
+	releases          []indexfile.Release
+	releaseIndexByTag map[string]uint
+	seenTags          map[string]bool
+
+	// refetch lists the paths of assets to download again even though a
+	// file is already present, e.g. because it failed verification.
+	refetch map[string]bool
+}
+
+func MirrorRepo(ctx context.Context, cfg *Config, repo *RepoConfig) error {
+	m, err := NewMirror(ctx, cfg, repo)
+	if err != nil {
+		return err
+	}
+	return m.Run(ctx)
+}
+
+// NewMirror sets up a Mirror for repo, including its GitHub API client.
+func NewMirror(ctx context.Context, cfg *Config, repo *RepoConfig) (*Mirror, error) {
+	logger := zerolog.Ctx(ctx)
+
+	var err error
+	var provenanceKey crypto.PublicKey
+	if repo.ProvenancePublicKey != "" {
+		provenanceKey, err = LoadPublicKey(repo.ProvenancePublicKey)
+		if err != nil {
+			logger.Error().
+				Str("keyFile", repo.ProvenancePublicKey).
+				Err(err).
+				Msg("failed to load provenance public key")
+			return nil, err
+		}
+	}
+
+	naming, err := cfg.RepoNaming(repo)
+	if err != nil {
+		logger.Error().
+			Err(err).
+			Msg("failed to compile asset naming rules")
+		return nil, err
+	}
+
+	host := cfg.RepoHost(repo)
+	transport, err := NewTransport(host.CABundle)
+	if err != nil {
+		logger.Error().
+			Str("caBundle", host.CABundle).
+			Err(err).
+			Msg("failed to load CA bundle")
+		return nil, err
+	}
+
+	githubURL := cfg.RepoGitHubURL(repo)
+	source, err := NewTokenSource(ctx, cfg, repo, transport)
+	if err != nil {
+		logger.Error().
+			Err(err).
+			Msg("failed to obtain GitHub credentials")
+		return nil, err
+	}
+
+	client, httpClient, err := NewAuthenticatedClient(transport, source, githubURL, host.UploadURL)
+	if err != nil {
+		logger.Error().
+			Str("githubURL", githubURL).
+			Err(err).
+			Msg("failed to create GitHub API client")
+		return nil, err
+	}
+
+	// Asset downloads are redirected to a storage host which authorizes
+	// the request through a signed URL, and must not see the token.
+	storageClient := &http.Client{Transport: &MyRoundTripper{Next: transport}}
+
+	m := &Mirror{
+		Owner:     repo.Owner,
+		Repo:      repo.Repo,
+		OutputDir: cfg.RepoOutputDir(repo),
+		Client:    client,
+		HTTP:      httpClient,
+		Storage:   storageClient,
+
+		Filters:            repo.Filters,
+		Jobs:               cfg.Jobs,
+		Retry:              cfg.Retry.WithDefaults(),
+		RateLimit:          cfg.RateLimit.WithDefaults(),
+		SHA512:             cfg.SHA512,
+		ExtractArchives:    cfg.ExtractArchives,
+		OnChecksumMismatch: cfg.OnChecksumMismatch,
+		ProvenanceKey:      provenanceKey,
+		Naming:             naming,
+		Retention:          cfg.RepoRetention(repo).WithDefaults(),
+	}
+	return m, nil
+}
+
+func (m *Mirror) IndexFilePath() string {
+	return filepath.Join(m.OutputDir, indexfile.IndexFileName)
+}
+
+func (m *Mirror) Run(ctx context.Context) error {
+	logger := zerolog.Ctx(ctx).With().
+		Str("githubURL", m.Client.BaseURL.String()).
+		Str("githubOwner", m.Owner).
+		Str("githubRepo", m.Repo).
+		Str("outputDir", m.OutputDir).
+		Logger()
+	ctx = logger.WithContext(ctx)
+
+	err := m.loadIndex(ctx)
+	if err != nil {
+		return err
+	}
+
+	// If the rate limit budget runs low part way through, still mirror
+	// everything that was listed so far; the next run picks up the rest.
+	listErr := m.listReleases(ctx)
+	if listErr != nil && !errors.Is(listErr, ErrRateLimitBudget) {
+		return listErr
+	}
+
+	err = m.pruneReleases(ctx, listErr == nil)
+	if err != nil {
+		return err
+	}
+
+	err = m.syncAssets(ctx)
+	if err == nil {
+		err = listErr
+	}
+	return err
+}
+
+// syncAssets downloads any assets that are missing locally, verifies and
+// post-processes them, and writes the index.
+func (m *Mirror) syncAssets(ctx context.Context) error {
+	// A failed download or a checksum or provenance mismatch fails the
+	// sync, but the index is still written so that it records the assets
+	// that were downloaded and which of them failed verification.  Assets
+	// that are still missing have no digests and are left alone until a
+	// later run fetches them.
+	err := m.downloadAssets(ctx)
+	if err2 := m.verifyChecksums(ctx); err == nil {
+		err = err2
+	}
+	if err2 := m.verifyProvenance(ctx); err == nil {
+		err = err2
+	}
+	if err2 := m.extractArchives(ctx); err == nil {
+		err = err2
+	}
+	m.extractBuildIDs(ctx)
+	if err2 := m.writeIndex(ctx); err == nil {
+		err = err2
+	}
+	return err
+}
+
+func (m *Mirror) loadIndex(ctx context.Context) error {
+	indexFilePath := m.IndexFilePath()
+	indexLogger := zerolog.Ctx(ctx).With().
+		Str("path", indexFilePath).
+		Logger()
+
+	m.releases = make([]indexfile.Release, 0, 256)
+
+	raw, err := os.ReadFile(indexFilePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		indexLogger.Error().
+			Err(err).
+			Msg("failed to read contents of JSON index file")
+		return err
+	}
+	if err == nil {
+		ctx2 := indexLogger.WithContext(ctx)
+		err = indexutil.FromJSON(ctx2, &m.releases, raw)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.releaseIndexByTag = make(map[string]uint, len(m.releases))
+	for index, release := range m.releases {
+		m.releaseIndexByTag[release.Tag] = uint(index)
+	}
+	return nil
+}
+
+func (m *Mirror) writeIndex(ctx context.Context) error {
+	indexFilePath := m.IndexFilePath()
+	indexLogger := zerolog.Ctx(ctx).With().
+		Str("path", indexFilePath).
+		Logger()
+
+	ctx2 := indexLogger.WithContext(ctx)
+	raw := indexutil.ToJSON(ctx2, m.releases)
+	err := indexutil.WriteFile(ctx2, indexFilePath, raw, 0o666)
+	if err != nil {
+		indexLogger.Error().
+			Err(err).
+			Msgf("failed to write contents of new JSON index file")
+		return err
+	}
+	return nil
+}
+
+func (m *Mirror) listReleases(ctx context.Context) error {
+	ghLogger := zerolog.Ctx(ctx)
+	m.seenTags = make(map[string]bool, len(m.releases))
+
+	err := Iterate(
+		ReleasesPerPage,
+		func(options *github.ListOptions) ([]*github.RepositoryRelease, *github.Response, error) {
+			var list []*github.RepositoryRelease
+			var resp *github.Response
+			ctx2 := ghLogger.With().Int("pageNumber", options.Page).Logger().WithContext(ctx)
+			err := m.callGitHub(ctx2, "list GitHub releases", func() (*github.Response, error) {
+				var err error
+				list, resp, err = m.Client.Repositories.ListReleases(ctx, m.Owner, m.Repo, options)
+				return resp, err
+			})
+			return list, resp, err
+		},
+		func(ghr *github.RepositoryRelease) error {
+			return m.processRelease(ctx, ghr)
+		},
+	)
+
+	kept := m.Filters.Apply(m.releases)
+	if len(kept) != len(m.releases) {
+		ghLogger.Debug().
+			Int("numDropped", len(m.releases)-len(kept)).
+			Msg("dropping releases excluded by filters from the index")
+	}
+	m.releases = kept
+
+	type ReleaseList = indexfile.SortableList[indexfile.Release]
+	ReleaseList(m.releases).Sort()
+	m.releaseIndexByTag = make(map[string]uint, len(m.releases))
+	for index, release := range m.releases {
+		m.releaseIndexByTag[release.Tag] = uint(index)
+	}
+	return err
+}
+
+func (m *Mirror) processRelease(ctx context.Context, ghr *github.RepositoryRelease) error {
+	if ghr.GetDraft() {
+		return nil
+	}
+
+	id := ghr.GetID()
+	tag := ghr.GetTagName()
+	m.seenTags[tag] = true
+
+	ghrLogger := zerolog.Ctx(ctx).With().
+		Int64("releaseID", id).
+		Str("releaseTag", tag).
+		Logger()
+
+	var release indexfile.Release
+	releaseIndex, found := m.releaseIndexByTag[tag]
+	switch {
+	case found:
+		release = m.releases[releaseIndex]
+	default:
+		release.Tag = tag
+		if !release.Version.Parse(tag) {
+			ghrLogger.Error().
+				Msg("failed to parse GitHub release tag as a semantic version")
+			return nil
+		}
+	}
+
+	release.Prerelease = ghr.GetPrerelease() || release.Version.Prerelease != ""
+	release.PublishedAt = nil
+	if ghr.PublishedAt != nil {
+		publishedAt := ghr.PublishedAt.Time
+		release.PublishedAt = &publishedAt
+	}
+	if !m.Filters.Match(release) {
+		ghrLogger.Debug().
+			Msg("skipping GitHub release excluded by filters")
+		return nil
+	}
+
+	oldAssets := release.Assets
+	updatedAt := releaseUpdatedAt(ghr)
+	unchanged := found && release.UpdatedAt != nil && updatedAt != nil && release.UpdatedAt.Equal(*updatedAt)
+
+	release.ID = id
+	release.Withdrawn = false
+	release.Name = ghr.GetName()
+	release.Body = ghr.GetBody()
+	release.UpdatedAt = updatedAt
+	release.Assets = make([]indexfile.Asset, 2, 16)
+	release.Assets[0] = indexfile.MakeSourceTarballAsset(ghr.GetTarballURL())
+	release.Assets[1] = indexfile.MakeSourceZipballAsset(ghr.GetZipballURL())
+
+	addAsset := func(gha *github.ReleaseAsset) error {
+		release.Assets = append(
+			release.Assets,
+			m.Naming.MakeAsset(
+				gha.GetID(),
+				gha.GetBrowserDownloadURL(),
+				gha.GetName(),
+			),
+		)
+		return nil
+	}
+
+	// A release that has not changed since it was indexed needs no API
+	// calls beyond listing it, which saves most of the rate limit budget
+	// on repositories with a long history.
+	var err error
+	if unchanged {
+		for _, gha := range ghr.Assets {
+			_ = addAsset(gha)
+		}
+	} else {
+		err = Iterate(
+			AssetsPerPage,
+			func(options *github.ListOptions) ([]*github.ReleaseAsset, *github.Response, error) {
+				var list []*github.ReleaseAsset
+				var resp *github.Response
+				ctx2 := ghrLogger.With().Int("pageNumber", options.Page).Logger().WithContext(ctx)
+				err := m.callGitHub(ctx2, "list assets for GitHub release", func() (*github.Response, error) {
+					var err error
+					list, resp, err = m.Client.Repositories.ListReleaseAssets(ctx, m.Owner, m.Repo, id, options)
+					return resp, err
+				})
+				return list, resp, err
+			},
+			addAsset,
+		)
+	}
+	if err != nil {
+		return err
+	}
+
+	mirrored := release.Assets[:0]
+	for _, asset := range release.Assets {
+		if !m.Filters.Assets.Match(asset) {
+			if !m.Filters.Assets.ListExcluded {
+				continue
+			}
+			asset.NotMirrored = true
+		}
+		mirrored = append(mirrored, asset)
+	}
+	release.Assets = mirrored
+
+	numUpstream := len(release.Assets)
+	for index := 0; index < numUpstream; index++ {
+		asset := &release.Assets[index]
+		if asset.NotMirrored {
+			continue
+		}
+		for _, old := range oldAssets {
+			if asset.SameUpstream(old) {
+				asset.CopyLocalState(old)
+				break
+			}
+		}
+	}
+
+	// Files extracted from an archive are kept for as long as the archive
+	// itself is.
+	for _, old := range oldAssets {
+		if !old.Derived() {
+			continue
+		}
+		for index := 0; index < numUpstream; index++ {
+			if release.Assets[index].Name == old.Parent && !release.Assets[index].NotMirrored {
+				release.Assets = append(release.Assets, old)
+				break
+			}
+		}
+	}
+
+	type AssetList = indexfile.SortableList[indexfile.Asset]
+	AssetList(release.Assets).Sort()
+
+	switch {
+	case found:
+		m.releases[releaseIndex] = release
+	default:
+		releaseIndex = uint(len(m.releases))
+		m.releases = append(m.releases, release)
+		m.releaseIndexByTag[tag] = releaseIndex
+	}
+	return nil
+}
+
+// releaseUpdatedAt returns the last time that the release, or any of the
+// assets listed along with it, changed.  go-github does not expose the
+// release's own updated_at, so it is worked out from the other timestamps.
+func releaseUpdatedAt(ghr *github.RepositoryRelease) *time.Time {
+	var out time.Time
+	consider := func(ts *github.Timestamp) {
+		if ts != nil && ts.Time.After(out) {
+			out = ts.Time
+		}
+	}
+
+	consider(ghr.CreatedAt)
+	consider(ghr.PublishedAt)
+	for _, gha := range ghr.Assets {
+		consider(gha.CreatedAt)
+		consider(gha.UpdatedAt)
+	}
+	if out.IsZero() {
+		return nil
+	}
+	return &out
+}
+
+func (m *Mirror) extractBuildIDs(ctx context.Context) {
+	logger := zerolog.Ctx(ctx)
+
+	for releaseIndex := range m.releases {
+		release := &m.releases[releaseIndex]
+		releaseDir := filepath.Join(m.OutputDir, release.Tag)
+		for assetIndex := range release.Assets {
+			asset := &release.Assets[assetIndex]
+			if asset.Type != indexfile.ExecutableType || asset.NotMirrored || asset.Quarantined() {
+				continue
+			}
+			asset.ExtractBuildID(releaseDir)
+		}
+
+		// Binaries for different platforms may legitimately report
+		// different revisions, e.g. if they were built by separate CI
+		// jobs; the release records the most common one.
+		buildIDs := release.BuildIDs()
+		if len(buildIDs) == 0 {
+			continue
+		}
+		if len(buildIDs) > 1 {
+			logger.Warn().
+				Int64("releaseID", release.ID).
+				Str("releaseTag", release.Tag).
+				Strs("buildIDs", buildIDs).
+				Msg("executables within one release report different vcs.revision values")
+		}
+		release.Version.BuildID = buildIDs[0]
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/chronos-tachyon/github-asset-mirror/indexfile"
+	"github.com/chronos-tachyon/github-asset-mirror/indexutil"
+)
+
+func TestMirrorRepo_SkipsUnchangedReleases(t *testing.T) {
+	var numAssetLists int32
+	var updatedAt atomic.Value
+	updatedAt.Store("2024-01-02T03:04:05Z")
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asset := map[string]any{
+			"id":                   42,
+			"name":                 "tool-linux-amd64",
+			"browser_download_url": ts.URL + "/download/tool-linux-amd64",
+			"created_at":           "2024-01-02T03:04:05Z",
+			"updated_at":           updatedAt.Load(),
+		}
+		switch r.URL.Path {
+		case "/api/v3/repos/owner/repo/releases":
+			if r.URL.Query().Get("per_page") != "100" {
+				t.Errorf("expected 100 releases per page, got %q", r.URL.Query().Get("per_page"))
+			}
+			_ = json.NewEncoder(w).Encode([]map[string]any{{
+				"id":           1,
+				"tag_name":     "v1.0.0",
+				"created_at":   "2024-01-02T03:04:05Z",
+				"published_at": "2024-01-02T03:04:05Z",
+				"tarball_url":  ts.URL + "/source.tar.gz",
+				"zipball_url":  ts.URL + "/source.zip",
+				"assets":       []any{asset},
+			}})
+		case "/api/v3/repos/owner/repo/releases/1/assets":
+			atomic.AddInt32(&numAssetLists, 1)
+			_ = json.NewEncoder(w).Encode([]any{asset})
+		case "/api/v3/repos/owner/repo/releases/assets/42":
+			_, _ = w.Write([]byte("binary\n"))
+		case "/source.tar.gz", "/source.zip":
+			_, _ = w.Write([]byte("source\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	outputDir := t.TempDir()
+	cfg := &Config{
+		OutputDir: outputDir,
+		GitHubURL: ts.URL,
+		Anonymous: true,
+		Retry:     RetryPolicy{MaxAttempts: 1},
+	}
+	repo := &RepoConfig{Owner: "owner", Repo: "repo", OutputDir: "."}
+
+	run := func(expectedLists int32) {
+		t.Helper()
+		if err := MirrorRepo(ctx, cfg, repo); err != nil {
+			t.Fatal(err)
+		}
+		if actual := atomic.LoadInt32(&numAssetLists); actual != expectedLists {
+			t.Errorf("expected %d asset list calls, got %d", expectedLists, actual)
+		}
+		if _, err := os.Stat(filepath.Join(outputDir, "v1.0.0", "tool-linux-amd64")); err != nil {
+			t.Errorf("asset was not mirrored: %v", err)
+		}
+	}
+
+	run(1)
+	run(1)
+	updatedAt.Store("2024-02-03T04:05:06Z")
+	run(2)
+}
+
+func TestMirrorRepo_WithdrawnRelease(t *testing.T) {
+	var withdrawn atomic.Bool
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if withdrawn.Load() {
+			if r.URL.Path == "/api/v3/repos/owner/repo/releases" {
+				_ = json.NewEncoder(w).Encode([]any{})
+				return
+			}
+			http.NotFound(w, r)
+			return
+		}
+		asset := map[string]any{
+			"id":                   42,
+			"name":                 "tool-linux-amd64",
+			"browser_download_url": ts.URL + "/download/tool-linux-amd64",
+			"created_at":           "2024-01-02T03:04:05Z",
+			"updated_at":           "2024-01-02T03:04:05Z",
+		}
+		switch r.URL.Path {
+		case "/api/v3/repos/owner/repo/releases":
+			_ = json.NewEncoder(w).Encode([]map[string]any{{
+				"id":           1,
+				"tag_name":     "v1.0.0",
+				"created_at":   "2024-01-02T03:04:05Z",
+				"published_at": "2024-01-02T03:04:05Z",
+				"tarball_url":  ts.URL + "/source.tar.gz",
+				"zipball_url":  ts.URL + "/source.zip",
+				"assets":       []any{asset},
+			}})
+		case "/api/v3/repos/owner/repo/releases/1/assets":
+			_ = json.NewEncoder(w).Encode([]any{asset})
+		case "/api/v3/repos/owner/repo/releases/assets/42":
+			_, _ = w.Write([]byte("binary\n"))
+		case "/source.tar.gz", "/source.zip":
+			_, _ = w.Write([]byte("source\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	outputDir := t.TempDir()
+	cfg := &Config{
+		OutputDir: outputDir,
+		GitHubURL: ts.URL,
+		Anonymous: true,
+		Retry:     RetryPolicy{MaxAttempts: 1},
+	}
+	repo := &RepoConfig{Owner: "owner", Repo: "repo", OutputDir: "."}
+
+	if err := MirrorRepo(ctx, cfg, repo); err != nil {
+		t.Fatal(err)
+	}
+
+	// The release disappears upstream, and so does the local copy of one
+	// of its assets, which can now never be downloaded again.
+	withdrawn.Store(true)
+	if err := os.Remove(filepath.Join(outputDir, "v1.0.0", "tool-linux-amd64")); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := MirrorRepo(ctx, cfg, repo); err != nil {
+			t.Fatalf("run %d: %v", i+2, err)
+		}
+		raw, err := os.ReadFile(filepath.Join(outputDir, indexfile.IndexFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var releases []indexfile.Release
+		if err := indexutil.FromJSON(ctx, &releases, raw); err != nil {
+			t.Fatal(err)
+		}
+		if len(releases) != 1 || !releases[0].Withdrawn {
+			t.Errorf("run %d: expected v1.0.0 to be recorded as withdrawn, got %#v", i+2, releases)
+		}
+	}
+}
+
+func TestMirrorRepo_PartialDownload(t *testing.T) {
+	const goodData = "good\n"
+	const flakyData = "flaky\n"
+
+	sum := func(data string) string {
+		digest := indexutil.NewDigester(false)
+		_, _ = digest.Write([]byte(data))
+		return digest.SHA256()
+	}
+	sums := sum(goodData) + "  tool-linux-amd64\n" + sum(flakyData) + "  tool-linux-arm64\n"
+
+	var broken atomic.Bool
+	broken.Store(true)
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asset := func(id int, name string) map[string]any {
+			return map[string]any{
+				"id":                   id,
+				"name":                 name,
+				"browser_download_url": ts.URL + "/download/" + name,
+				"created_at":           "2024-01-02T03:04:05Z",
+				"updated_at":           "2024-01-02T03:04:05Z",
+			}
+		}
+		assets := []any{
+			asset(42, "tool-linux-amd64"),
+			asset(43, "tool-linux-arm64"),
+			asset(44, "SHA256SUMS"),
+		}
+		switch r.URL.Path {
+		case "/api/v3/repos/owner/repo/releases":
+			_ = json.NewEncoder(w).Encode([]map[string]any{{
+				"id":           1,
+				"tag_name":     "v1.0.0",
+				"created_at":   "2024-01-02T03:04:05Z",
+				"published_at": "2024-01-02T03:04:05Z",
+				"tarball_url":  ts.URL + "/source.tar.gz",
+				"zipball_url":  ts.URL + "/source.zip",
+				"assets":       assets,
+			}})
+		case "/api/v3/repos/owner/repo/releases/1/assets":
+			_ = json.NewEncoder(w).Encode(assets)
+		case "/api/v3/repos/owner/repo/releases/assets/42":
+			_, _ = w.Write([]byte(goodData))
+		case "/api/v3/repos/owner/repo/releases/assets/43":
+			if broken.Load() {
+				http.Error(w, "try again later", http.StatusBadGateway)
+				return
+			}
+			_, _ = w.Write([]byte(flakyData))
+		case "/api/v3/repos/owner/repo/releases/assets/44":
+			_, _ = w.Write([]byte(sums))
+		case "/source.tar.gz", "/source.zip":
+			_, _ = w.Write([]byte("source\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := context.Background()
+	outputDir := t.TempDir()
+	cfg := &Config{
+		OutputDir: outputDir,
+		GitHubURL: ts.URL,
+		Anonymous: true,
+		Jobs:      1,
+		Retry:     RetryPolicy{MaxAttempts: 1},
+	}
+	repo := &RepoConfig{Owner: "owner", Repo: "repo", OutputDir: "."}
+
+	readIndex := func() map[string]indexfile.Asset {
+		t.Helper()
+		raw, err := os.ReadFile(filepath.Join(outputDir, indexfile.IndexFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var releases []indexfile.Release
+		if err := indexutil.FromJSON(ctx, &releases, raw); err != nil {
+			t.Fatal(err)
+		}
+		out := make(map[string]indexfile.Asset)
+		for _, release := range releases {
+			for _, asset := range release.Assets {
+				out[asset.Name] = asset
+			}
+		}
+		return out
+	}
+
+	// One asset fails to download; the others are still mirrored, and the
+	// index records them without blaming the missing one.
+	if err := MirrorRepo(ctx, cfg, repo); err == nil {
+		t.Fatal("expected the failed download to be reported")
+	}
+	assets := readIndex()
+	if asset := assets["tool-linux-amd64"]; asset.SHA256 != sum(goodData) || asset.Checksum != indexfile.VerifiedStatus {
+		t.Errorf("tool-linux-amd64: expected a verified download, got %#v", asset)
+	}
+	if asset := assets["tool-linux-arm64"]; asset.SHA256 != "" || asset.Checksum != indexfile.UnverifiedStatus {
+		t.Errorf("tool-linux-arm64: expected no download yet, got %#v", asset)
+	}
+
+	broken.Store(false)
+	if err := MirrorRepo(ctx, cfg, repo); err != nil {
+		t.Fatal(err)
+	}
+	assets = readIndex()
+	if asset := assets["tool-linux-arm64"]; asset.SHA256 != sum(flakyData) || asset.Checksum != indexfile.VerifiedStatus {
+		t.Errorf("tool-linux-arm64: expected a verified download, got %#v", asset)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/chronos-tachyon/github-asset-mirror/indexfile"
+)
+
+// NamingRuleConfig is either a reference to a built-in preset or a single
+// pattern with named captures "base", "os", "arch", "type" and "libc".  The
+// remaining fields supply values for anything the pattern does not capture.
+type NamingRuleConfig struct {
+	Preset  string              `yaml:"preset,omitempty"`
+	Pattern string              `yaml:"pattern,omitempty"`
+	OS      indexfile.AssetOS   `yaml:"os,omitempty"`
+	Arch    indexfile.AssetArch `yaml:"arch,omitempty"`
+	Type    indexfile.AssetType `yaml:"type,omitempty"`
+	Libc    string              `yaml:"libc,omitempty"`
+}
+
+// CompileNamingRules expands presets and compiles patterns, in order.  An
+// empty list yields indexfile.DefaultNamingRules.
+func CompileNamingRules(list []NamingRuleConfig) (indexfile.NamingRules, error) {
+	if len(list) == 0 {
+		return indexfile.DefaultNamingRules, nil
+	}
+
+	out := make(indexfile.NamingRules, 0, len(list))
+	for index, rc := range list {
+		switch {
+		case rc.Preset != "" && rc.Pattern != "":
+			return nil, fmt.Errorf("naming[%d]: \"preset\" and \"pattern\" are mutually exclusive", index)
+
+		case rc.Preset != "":
+			rules, err := indexfile.NamingPreset(rc.Preset)
+			if err != nil {
+				return nil, fmt.Errorf("naming[%d]: %w", index, err)
+			}
+			out = append(out, rules...)
+
+		case rc.Pattern != "":
+			re, err := regexp.Compile(rc.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("naming[%d]: failed to compile pattern: %w", index, err)
+			}
+			out = append(out, indexfile.NamingRule{
+				Pattern: re,
+				OS:      rc.OS,
+				Arch:    rc.Arch,
+				Type:    rc.Type,
+				Libc:    rc.Libc,
+			})
+
+		default:
+			return nil, fmt.Errorf("naming[%d]: one of \"preset\" or \"pattern\" is required", index)
+		}
+	}
+	return out, nil
+}
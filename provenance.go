@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"github.com/chronos-tachyon/github-asset-mirror/indexfile"
+	"github.com/chronos-tachyon/github-asset-mirror/indexutil"
+)
+
+const InTotoPayloadType = "application/vnd.in-toto+json"
+
+// DSSEEnvelope is a Dead Simple Signing Envelope, one of which appears on
+// each line of an "*.intoto.jsonl" provenance file.
+type DSSEEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []DSSESignature `json:"signatures"`
+}
+
+type DSSESignature struct {
+	KeyID string `json:"keyid,omitempty"`
+	Sig   string `json:"sig"`
+}
+
+// InTotoStatement is the payload of a DSSEEnvelope.  Only the fields needed
+// to match subjects against assets are decoded.
+type InTotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []InTotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+}
+
+type InTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// PAE returns the DSSE pre-authentication encoding of the envelope, which is
+// the message that the signatures are computed over.
+func (env *DSSEEnvelope) PAE(payload []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("DSSEv1 ")
+	buf.WriteString(strconv.Itoa(len(env.PayloadType)))
+	buf.WriteByte(' ')
+	buf.WriteString(env.PayloadType)
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.Itoa(len(payload)))
+	buf.WriteByte(' ')
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+// Verify reports whether any of the envelope's signatures is valid for key.
+func (env *DSSEEnvelope) Verify(key crypto.PublicKey, payload []byte) bool {
+	message := env.PAE(payload)
+	digest := sha256.Sum256(message)
+	for _, s := range env.Signatures {
+		sig, err := decodeBase64(s.Sig)
+		if err != nil {
+			continue
+		}
+		switch k := key.(type) {
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(k, ecdsaDigest(k, message), sig) {
+				return true
+			}
+		case ed25519.PublicKey:
+			if ed25519.Verify(k, message, sig) {
+				return true
+			}
+		case *rsa.PublicKey:
+			if rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil {
+				return true
+			}
+			if rsa.VerifyPSS(k, crypto.SHA256, digest[:], sig, nil) == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ecdsaDigest hashes message with the hash that goes with the curve of key:
+// SHA-256 for P-256, SHA-384 for P-384 and SHA-512 for P-521.
+func ecdsaDigest(key *ecdsa.PublicKey, message []byte) []byte {
+	bits := key.Curve.Params().BitSize
+	switch {
+	case bits > 384:
+		sum := sha512.Sum512(message)
+		return sum[:]
+	case bits > 256:
+		sum := sha512.Sum384(message)
+		return sum[:]
+	default:
+		sum := sha256.Sum256(message)
+		return sum[:]
+	}
+}
+
+func decodeBase64(str string) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(str)
+	if err != nil {
+		raw, err = base64.URLEncoding.DecodeString(str)
+	}
+	return raw, err
+}
+
+// ParseProvenanceFile parses each line of an "*.intoto.jsonl" file as a DSSE
+// envelope and returns the envelopes along with their decoded statements.
+func ParseProvenanceFile(raw []byte) ([]DSSEEnvelope, []InTotoStatement, [][]byte, error) {
+	var envs []DSSEEnvelope
+	var stmts []InTotoStatement
+	var payloads [][]byte
+
+	s := bufio.NewScanner(bytes.NewReader(raw))
+	s.Buffer(nil, 16<<20) // 16 MiB
+	lineNum := 0
+	for s.Scan() {
+		lineNum++
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var env DSSEEnvelope
+		err := json.Unmarshal(line, &env)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("line %d: failed to decode DSSE envelope: %w", lineNum, err)
+		}
+		if env.PayloadType != InTotoPayloadType {
+			return nil, nil, nil, fmt.Errorf("line %d: unexpected DSSE payload type %q", lineNum, env.PayloadType)
+		}
+
+		payload, err := decodeBase64(env.Payload)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("line %d: failed to decode DSSE payload: %w", lineNum, err)
+		}
+
+		var stmt InTotoStatement
+		err = json.Unmarshal(payload, &stmt)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("line %d: failed to decode in-toto statement: %w", lineNum, err)
+		}
+		if !strings.HasPrefix(stmt.Type, "https://in-toto.io/Statement/") {
+			return nil, nil, nil, fmt.Errorf("line %d: unexpected in-toto statement type %q", lineNum, stmt.Type)
+		}
+
+		envs = append(envs, env)
+		stmts = append(stmts, stmt)
+		payloads = append(payloads, payload)
+	}
+	if err := s.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+	return envs, stmts, payloads, nil
+}
+
+// LoadPublicKey reads a PEM-encoded PKIX public key (ECDSA, Ed25519 or RSA).
+func LoadPublicKey(keyFile string) (crypto.PublicKey, error) {
+	raw, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(raw)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("%s: expected a PEM block of type \"PUBLIC KEY\"", keyFile)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", keyFile, err)
+	}
+	return key, nil
+}
+
+// verifyProvenance checks every downloaded asset named as the subject of an
+// in-toto statement against the digest recorded in that statement.
+func (m *Mirror) verifyProvenance(ctx context.Context) error {
+	var firstErr error
+	for releaseIndex := range m.releases {
+		release := &m.releases[releaseIndex]
+		err := m.verifyReleaseProvenance(ctx, release)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (m *Mirror) verifyReleaseProvenance(ctx context.Context, release *indexfile.Release) error {
+	releaseDir := filepath.Join(m.OutputDir, release.Tag)
+	releaseLogger := zerolog.Ctx(ctx).With().
+		Int64("releaseID", release.ID).
+		Str("releaseTag", release.Tag).
+		Logger()
+
+	assetIndexByName := make(map[string]int, len(release.Assets))
+	for assetIndex, asset := range release.Assets {
+		assetIndexByName[asset.Name] = assetIndex
+	}
+
+	var firstErr error
+	for provIndex := range release.Assets {
+		prov := &release.Assets[provIndex]
+		if prov.Type != indexfile.ProvenanceType || prov.NotMirrored {
+			continue
+		}
+
+		provPath := filepath.Join(releaseDir, prov.Name)
+		provLogger := releaseLogger.With().
+			Int64("assetID", prov.ID).
+			Str("assetPath", provPath).
+			Logger()
+
+		raw, err := os.ReadFile(provPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			provLogger.Error().
+				Err(err).
+				Msg("failed to read provenance file")
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		envs, stmts, payloads, err := ParseProvenanceFile(raw)
+		if err != nil {
+			provLogger.Warn().
+				Err(err).
+				Msg("failed to parse provenance file; ignoring it")
+			continue
+		}
+
+		if m.ProvenanceKey != nil {
+			prov.Signature = indexfile.VerifiedStatus
+		}
+
+		for index := range envs {
+			// A statement that is not signed by the configured key
+			// says nothing about the assets it names, so it is only
+			// held against the provenance file itself.
+			if m.ProvenanceKey != nil && !envs[index].Verify(m.ProvenanceKey, payloads[index]) {
+				prov.Signature = indexfile.MismatchStatus
+				provLogger.Error().
+					Int("statement", index).
+					Msg("provenance signature does not match the configured public key")
+				continue
+			}
+
+			for _, subject := range stmts[index].Subject {
+				assetIndex, found := assetIndexByName[subject.Name]
+				if !found {
+					continue
+				}
+				asset := &release.Assets[assetIndex]
+				if asset.Provenance == indexfile.MismatchStatus && firstErr == nil {
+					firstErr = fmt.Errorf("%s/%s: digest does not match provenance statement in %s", release.Tag, asset.Name, prov.Name)
+				}
+				if asset.Provenance != indexfile.UnverifiedStatus && asset.Provenance != indexfile.VerifiedStatus {
+					continue
+				}
+				if asset.NotMirrored || asset.Quarantined() || asset.SHA256 == "" {
+					continue
+				}
+
+				assetPath := filepath.Join(releaseDir, asset.Name)
+				assetLogger := releaseLogger.With().
+					Int64("assetID", asset.ID).
+					Str("assetPath", assetPath).
+					Str("provenancePath", provPath).
+					Logger()
+
+				ok, err := checkSubjectDigest(subject, asset, assetPath)
+				if err != nil {
+					assetLogger.Error().
+						Err(err).
+						Msg("I/O error while computing digests of downloaded asset")
+					if firstErr == nil {
+						firstErr = err
+					}
+					continue
+				}
+				if ok {
+					asset.Provenance = indexfile.VerifiedStatus
+					continue
+				}
+
+				asset.Provenance = indexfile.MismatchStatus
+				err = fmt.Errorf("%s/%s: digest does not match provenance statement in %s", release.Tag, asset.Name, prov.Name)
+				if m.OnChecksumMismatch != QuarantineOnMismatch {
+					assetLogger.Error().
+						Msg("downloaded asset does not match provenance statement")
+					if firstErr == nil {
+						firstErr = err
+					}
+					continue
+				}
+
+				assetLogger.Warn().
+					Msg("downloaded asset does not match provenance statement; moving it to quarantine")
+				ctx2 := assetLogger.WithContext(ctx)
+				err = m.quarantine(ctx2, release, asset)
+				if err == nil {
+					asset.Provenance = indexfile.QuarantinedStatus
+				} else if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}
+	}
+	return firstErr
+}
+
+// checkSubjectDigest is matchSubjectDigest for a downloaded asset, computing
+// its SHA-512 digest from assetPath first if subject has one and the index
+// does not.
+func checkSubjectDigest(subject InTotoSubject, asset *indexfile.Asset, assetPath string) (bool, error) {
+	for algo := range subject.Digest {
+		if strings.EqualFold(algo, "sha512") && asset.SHA512 == "" {
+			digest, err := indexutil.DigestFile(assetPath, true)
+			if err != nil {
+				return false, err
+			}
+			setDigests(asset, digest)
+			break
+		}
+	}
+	return matchSubjectDigest(subject, *asset), nil
+}
+
+// matchSubjectDigest reports whether subject names the exact contents of
+// asset.  At least one digest algorithm known to both sides must be present.
+func matchSubjectDigest(subject InTotoSubject, asset indexfile.Asset) bool {
+	matched := false
+	for algo, hexDigest := range subject.Digest {
+		var want string
+		switch strings.ToLower(algo) {
+		case "sha256":
+			want = asset.SHA256
+		case "sha512":
+			want = asset.SHA512
+		default:
+			continue
+		}
+		if want == "" {
+			continue
+		}
+		if !strings.EqualFold(hexDigest, want) {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chronos-tachyon/github-asset-mirror/indexfile"
+)
+
+func TestDSSEEnvelope_PAE(t *testing.T) {
+	// Test vector from the DSSE specification.
+	env := DSSEEnvelope{PayloadType: "http://example.com/HelloWorld"}
+	expected := "DSSEv1 29 http://example.com/HelloWorld 11 hello world"
+	if actual := string(env.PAE([]byte("hello world"))); actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+
+	env = DSSEEnvelope{PayloadType: ""}
+	expected = "DSSEv1 0  0 "
+	if actual := string(env.PAE(nil)); actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+}
+
+func TestDSSEEnvelope_Verify(t *testing.T) {
+	payload := []byte(`{"_type":"https://in-toto.io/Statement/v0.1"}`)
+	message := (&DSSEEnvelope{PayloadType: InTotoPayloadType}).PAE(payload)
+	digest := sha256.Sum256(message)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecSig, err := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ec384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest384 := sha512.Sum384(message)
+	ec384Sig, err := ecdsa.SignASN1(rand.Reader, ec384Key, digest384[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ec521Key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest521 := sha512.Sum512(message)
+	ec521Sig, err := ecdsa.SignASN1(rand.Reader, ec521Key, digest521[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edSig := ed25519.Sign(edKey, message)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pssSig, err := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest[:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	std := base64.StdEncoding.EncodeToString
+	url := base64.URLEncoding.EncodeToString
+
+	type testRow struct {
+		Name     string
+		Key      crypto.PublicKey
+		Sigs     []string
+		Payload  []byte
+		Expected bool
+	}
+
+	testData := [...]testRow{
+		{"ecdsa", &ecKey.PublicKey, []string{std(ecSig)}, payload, true},
+		{"ecdsa-url-base64", &ecKey.PublicKey, []string{url(ecSig)}, payload, true},
+		{"ecdsa-p384-sha384", &ec384Key.PublicKey, []string{std(ec384Sig)}, payload, true},
+		{"ecdsa-p521-sha512", &ec521Key.PublicKey, []string{std(ec521Sig)}, payload, true},
+		{"ed25519", edPub, []string{std(edSig)}, payload, true},
+		{"rsa-pkcs1v15", &rsaKey.PublicKey, []string{std(rsaSig)}, payload, true},
+		{"rsa-pss", &rsaKey.PublicKey, []string{std(pssSig)}, payload, true},
+		{"second-signature", &ecKey.PublicKey, []string{std(edSig), "!!!", std(ecSig)}, payload, true},
+		{"wrong-key", &otherKey.PublicKey, []string{std(ecSig)}, payload, false},
+		{"wrong-key-type", edPub, []string{std(ecSig)}, payload, false},
+		{"tampered-payload", &ecKey.PublicKey, []string{std(ecSig)}, []byte(`{"_type":"evil"}`), false},
+		{"bad-base64", &ecKey.PublicKey, []string{"!!!"}, payload, false},
+		{"no-signatures", &ecKey.PublicKey, nil, payload, false},
+	}
+
+	for _, row := range testData {
+		t.Run(row.Name, func(t *testing.T) {
+			env := DSSEEnvelope{PayloadType: InTotoPayloadType}
+			for _, sig := range row.Sigs {
+				env.Signatures = append(env.Signatures, DSSESignature{Sig: sig})
+			}
+			if actual := env.Verify(row.Key, row.Payload); actual != row.Expected {
+				t.Errorf("expected %v, got %v", row.Expected, actual)
+			}
+		})
+	}
+}
+
+func TestCheckSubjectDigest(t *testing.T) {
+	// The digests of the file contents "test".
+	const sha256a = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	const sha256b = "60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752"
+	const sha512a = "ee26b0dd4af7e749aa1a8ee3c10ae9923f618980772e473f8819a5d4940e0db27ac185f8a0e1d5f84f88bc887fd67b143732c304cc5fa9ad8e6f57f50028a8ff"
+
+	withSHA256 := indexfile.Asset{SHA256: sha256a}
+	withBoth := indexfile.Asset{SHA256: sha256a, SHA512: sha512a}
+
+	type testRow struct {
+		Name     string
+		Digest   map[string]string
+		Asset    indexfile.Asset
+		Expected bool
+	}
+
+	testData := [...]testRow{
+		{"sha256", map[string]string{"sha256": sha256a}, withSHA256, true},
+		{"sha256-uppercase", map[string]string{"SHA256": "9F86D081884C7D659A2FEAA0C55AD015A3BF4F1B2B0B822CD15D6C15B0F00A08"}, withSHA256, true},
+		{"sha256-mismatch", map[string]string{"sha256": sha256b}, withSHA256, false},
+		{"sha512", map[string]string{"sha512": sha512a}, withBoth, true},
+		{"sha512-unknown-locally", map[string]string{"sha512": sha512a}, withSHA256, true},
+		{"both", map[string]string{"sha256": sha256a, "sha512": sha512a}, withBoth, true},
+		{"one-of-two-mismatches", map[string]string{"sha256": sha256b, "sha512": sha512a}, withBoth, false},
+		{"extra-unknown-algorithm", map[string]string{"sha256": sha256a, "gitCommit": "abc123"}, withSHA256, true},
+		{"only-unknown-algorithms", map[string]string{"sha1": "abc123"}, withBoth, false},
+		{"no-digests", nil, withBoth, false},
+		{"asset-without-digests", map[string]string{"sha256": sha256a}, indexfile.Asset{}, false},
+	}
+
+	assetPath := filepath.Join(t.TempDir(), "foo.tar.gz")
+	if err := os.WriteFile(assetPath, []byte("test"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, row := range testData {
+		t.Run(row.Name, func(t *testing.T) {
+			subject := InTotoSubject{Name: "foo.tar.gz", Digest: row.Digest}
+			asset := row.Asset
+			actual, err := checkSubjectDigest(subject, &asset, assetPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != row.Expected {
+				t.Errorf("expected %v, got %v", row.Expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseProvenanceFile(t *testing.T) {
+	envelope := func(payloadType string, statement any) string {
+		payload, err := json.Marshal(statement)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := json.Marshal(DSSEEnvelope{
+			PayloadType: payloadType,
+			Payload:     base64.StdEncoding.EncodeToString(payload),
+			Signatures:  []DSSESignature{{Sig: "c2ln"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(raw)
+	}
+
+	good := envelope(InTotoPayloadType, InTotoStatement{
+		Type:          "https://in-toto.io/Statement/v0.1",
+		PredicateType: "https://slsa.dev/provenance/v0.2",
+		Subject:       []InTotoSubject{{Name: "foo.tar.gz", Digest: map[string]string{"sha256": "abc"}}},
+	})
+
+	envs, stmts, payloads, err := ParseProvenanceFile([]byte(good + "\r\n\n" + good + "\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(envs) != 2 || len(stmts) != 2 || len(payloads) != 2 {
+		t.Fatalf("expected 2 statements, got %d, %d, %d", len(envs), len(stmts), len(payloads))
+	}
+	if len(stmts[0].Subject) != 1 || stmts[0].Subject[0].Name != "foo.tar.gz" {
+		t.Errorf("wrong subjects: %+v", stmts[0].Subject)
+	}
+
+	type testRow struct {
+		Name  string
+		Input string
+	}
+
+	testData := [...]testRow{
+		{"not-json", "{"},
+		{"wrong-payload-type", envelope("application/json", InTotoStatement{Type: "https://in-toto.io/Statement/v0.1"})},
+		{"wrong-statement-type", envelope(InTotoPayloadType, InTotoStatement{Type: "https://example.com/Statement"})},
+		{"bad-payload", `{"payloadType":"` + InTotoPayloadType + `","payload":"!!!"}`},
+	}
+
+	for _, row := range testData {
+		t.Run(row.Name, func(t *testing.T) {
+			_, _, _, err := ParseProvenanceFile([]byte(good + "\n" + row.Input + "\n"))
+			if err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestLoadPublicKey(t *testing.T) {
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(edPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	goodPath := filepath.Join(dir, "good.pem")
+	badPath := filepath.Join(dir, "bad.pem")
+	if err := os.WriteFile(goodPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(badPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := LoadPublicKey(goodPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if loaded, ok := key.(ed25519.PublicKey); !ok || !loaded.Equal(edPub) {
+		t.Errorf("loaded the wrong key: %#v", key)
+	}
+
+	if _, err := LoadPublicKey(badPath); err == nil {
+		t.Errorf("expected an error for a PEM block of the wrong type")
+	}
+	if _, err := LoadPublicKey(filepath.Join(dir, "missing.pem")); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
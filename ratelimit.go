@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v48/github"
+	"github.com/rs/zerolog"
+)
+
+const (
+	DefaultAbuseRetryAfter  = 60 * time.Second
+	DefaultRateLimitMaxWait = 2 * time.Hour
+)
+
+// ErrRateLimitBudget is returned when the remaining GitHub API request budget
+// drops below the configured floor.  Everything mirrored up to that point is
+// kept, and the next run resumes where this one stopped.
+var ErrRateLimitBudget = errors.New("GitHub API rate limit budget exhausted")
+
+type RateLimitPolicy struct {
+	MinRemaining int           `yaml:"minRemaining,omitempty"`
+	MaxWait      time.Duration `yaml:"maxWait,omitempty"`
+}
+
+func (p RateLimitPolicy) Validate() error {
+	if p.MinRemaining < 0 {
+		return fmt.Errorf("rateLimit.minRemaining: must not be negative, got %d", p.MinRemaining)
+	}
+	if p.MaxWait < 0 {
+		return fmt.Errorf("rateLimit.maxWait: must not be negative, got %v", p.MaxWait)
+	}
+	return nil
+}
+
+// WithDefaults returns a copy of p with all unset fields filled in.
+func (p RateLimitPolicy) WithDefaults() RateLimitPolicy {
+	if p.MaxWait == 0 {
+		p.MaxWait = DefaultRateLimitMaxWait
+	}
+	return p
+}
+
+// WaitTime reports how long to pause before retrying after err, if err is a
+// primary or secondary rate limit error.
+func (p RateLimitPolicy) WaitTime(err error) (time.Duration, bool) {
+	var rateErr *github.RateLimitError
+	if errors.As(err, &rateErr) {
+		// Add a second of slack in case our clock is behind GitHub's.
+		wait := time.Until(rateErr.Rate.Reset.Time) + time.Second
+		if wait < time.Second {
+			wait = time.Second
+		}
+		return wait, true
+	}
+
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		wait := DefaultAbuseRetryAfter
+		if abuseErr.RetryAfter != nil && *abuseErr.RetryAfter > 0 {
+			wait = *abuseErr.RetryAfter
+		}
+		return wait, true
+	}
+
+	return 0, false
+}
+
+// CheckBudget returns ErrRateLimitBudget if resp shows that fewer than
+// MinRemaining requests remain in the current rate limit window.
+func (p RateLimitPolicy) CheckBudget(resp *github.Response) error {
+	if p.MinRemaining <= 0 || resp == nil || resp.Rate.Limit == 0 {
+		return nil
+	}
+	if resp.Rate.Remaining >= p.MinRemaining {
+		return nil
+	}
+	return fmt.Errorf("%w: %d of %d requests remaining until %v", ErrRateLimitBudget, resp.Rate.Remaining, resp.Rate.Limit, resp.Rate.Reset.Time)
+}
+
+// CheckRateLimit returns the rate limit error described by resp, a response
+// from the GitHub API to a request made without the go-github client, or nil
+// if resp does not show that a rate limit was hit.  Besides the cases that
+// go-github recognises, a 403 or 429 with a Retry-After header counts as a
+// secondary rate limit.
+func CheckRateLimit(resp *http.Response) error {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+
+	err := github.CheckResponse(resp)
+	var rateErr *github.RateLimitError
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &rateErr) || errors.As(err, &abuseErr) {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.Header.Get("retry-after") != "" {
+		abuseErr = &github.AbuseRateLimitError{Response: resp, Message: resp.Status}
+		if retryAfter := NewHTTPStatusError(resp).RetryAfter; retryAfter > 0 {
+			abuseErr.RetryAfter = &retryAfter
+		}
+		return abuseErr
+	}
+	return nil
+}
+
+// ResponseRate wraps resp, a response from the GitHub API to a request made
+// without the go-github client, so that its rate limit headers can be
+// checked with CheckBudget.
+func ResponseRate(resp *http.Response) *github.Response {
+	out := &github.Response{Response: resp}
+	if str := resp.Header.Get("x-ratelimit-limit"); str != "" {
+		out.Rate.Limit, _ = strconv.Atoi(str)
+	}
+	if str := resp.Header.Get("x-ratelimit-remaining"); str != "" {
+		out.Rate.Remaining, _ = strconv.Atoi(str)
+	}
+	if str := resp.Header.Get("x-ratelimit-reset"); str != "" {
+		if secs, err := strconv.ParseInt(str, 10, 64); err == nil && secs != 0 {
+			out.Rate.Reset = github.Timestamp{Time: time.Unix(secs, 0)}
+		}
+	}
+	return out
+}
+
+// callGitHub performs a GitHub API call, pausing whenever a rate limit is hit
+// and retrying transient failures according to the mirror's retry policy.
+func (m *Mirror) callGitHub(ctx context.Context, what string, fn func() (*github.Response, error)) error {
+	logger := zerolog.Ctx(ctx)
+
+	var resp *github.Response
+	err := m.Retry.Do(ctx, what, func() error {
+		for {
+			var err error
+			resp, err = fn()
+			if err == nil {
+				return nil
+			}
+
+			wait, ok := m.RateLimit.WaitTime(err)
+			if !ok {
+				return err
+			}
+			if wait > m.RateLimit.MaxWait {
+				logger.Error().
+					Dur("wait", wait).
+					Dur("maxWait", m.RateLimit.MaxWait).
+					Msg("GitHub API rate limit will not reset soon enough")
+				return err
+			}
+
+			logger.Warn().
+				Dur("wait", wait).
+				Time("until", time.Now().Add(wait)).
+				Err(err).
+				Msg("GitHub API rate limit exceeded; waiting")
+
+			err = sleepContext(ctx, wait)
+			if err != nil {
+				return err
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	err = m.RateLimit.CheckBudget(resp)
+	if err != nil {
+		logger.Warn().
+			Int("remaining", resp.Rate.Remaining).
+			Int("limit", resp.Rate.Limit).
+			Int("minRemaining", m.RateLimit.MinRemaining).
+			Time("reset", resp.Rate.Reset.Time).
+			Msg("GitHub API rate limit budget is below the configured floor; stopping early")
+	}
+	return err
+}
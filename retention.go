@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/chronos-tachyon/github-asset-mirror/indexfile"
+	"github.com/chronos-tachyon/github-asset-mirror/indexutil"
+)
+
+// AtticDirName is the directory beneath each repository's output directory
+// where the files of pruned releases are moved.
+const AtticDirName = ".attic"
+
+const (
+	WithdrawRelease = "withdraw"
+	AtticRelease    = "attic"
+	DeleteRelease   = "delete"
+)
+
+// RetentionPolicy decides which releases are kept in the mirror.  A release
+// is kept if any of the keep rules selects it; if no keep rule is set, every
+// release is kept.  Releases that are deleted upstream, or turned back into
+// drafts, are handled according to OnUpstreamDelete whether or not a keep
+// rule is set.
+type RetentionPolicy struct {
+	KeepLast         int           `yaml:"keepLast,omitempty"`
+	KeepPerMajor     int           `yaml:"keepPerMajor,omitempty"`
+	KeepNewerThan    time.Duration `yaml:"keepNewerThan,omitempty"`
+	OnExpire         string        `yaml:"onExpire,omitempty"`
+	OnUpstreamDelete string        `yaml:"onUpstreamDelete,omitempty"`
+}
+
+func (p RetentionPolicy) Validate() error {
+	if p.KeepLast < 0 {
+		return fmt.Errorf("retention.keepLast: must not be negative, got %d", p.KeepLast)
+	}
+	if p.KeepPerMajor < 0 {
+		return fmt.Errorf("retention.keepPerMajor: must not be negative, got %d", p.KeepPerMajor)
+	}
+	if p.KeepNewerThan < 0 {
+		return fmt.Errorf("retention.keepNewerThan: must not be negative, got %v", p.KeepNewerThan)
+	}
+	switch p.OnExpire {
+	case "", AtticRelease, DeleteRelease:
+		// pass
+	default:
+		return fmt.Errorf("retention.onExpire: must be %q or %q, got %q", AtticRelease, DeleteRelease, p.OnExpire)
+	}
+	switch p.OnUpstreamDelete {
+	case "", WithdrawRelease, AtticRelease, DeleteRelease:
+		// pass
+	default:
+		return fmt.Errorf("retention.onUpstreamDelete: must be %q, %q or %q, got %q", WithdrawRelease, AtticRelease, DeleteRelease, p.OnUpstreamDelete)
+	}
+	return nil
+}
+
+// WithDefaults returns a copy of p with all unset fields filled in.
+func (p RetentionPolicy) WithDefaults() RetentionPolicy {
+	if p.OnExpire == "" {
+		p.OnExpire = AtticRelease
+	}
+	if p.OnUpstreamDelete == "" {
+		p.OnUpstreamDelete = WithdrawRelease
+	}
+	return p
+}
+
+// Enabled reports whether any keep rule is set.
+func (p RetentionPolicy) Enabled() bool {
+	return p.KeepLast > 0 || p.KeepPerMajor > 0 || p.KeepNewerThan > 0
+}
+
+// Expired returns the tags of the releases that no keep rule selects.
+// Withdrawn releases do not count towards keepLast or keepPerMajor, and they
+// only expire if onUpstreamDelete asks for releases deleted upstream to be
+// pruned; otherwise they stay listed as withdrawn.  Releases whose
+// publication date is unknown are never too old.
+func (p RetentionPolicy) Expired(releases []indexfile.Release, now time.Time) map[string]bool {
+	if !p.Enabled() {
+		return nil
+	}
+
+	sorted := make([]indexfile.Release, len(releases))
+	copy(sorted, releases)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return indexfile.ComparePrecedence(sorted[i].Version, sorted[j].Version) == indexfile.GT
+	})
+
+	keep := make(map[string]bool, len(sorted))
+	numKept := 0
+	numKeptByMajor := make(map[uint]int, 8)
+	for _, release := range sorted {
+		if release.Withdrawn {
+			continue
+		}
+		if numKept < p.KeepLast {
+			keep[release.Tag] = true
+		}
+		numKept++
+		if numKeptByMajor[release.Version.Major] < p.KeepPerMajor {
+			keep[release.Tag] = true
+		}
+		numKeptByMajor[release.Version.Major]++
+	}
+
+	pruneWithdrawn := p.OnUpstreamDelete == AtticRelease || p.OnUpstreamDelete == DeleteRelease
+
+	out := make(map[string]bool, len(sorted))
+	for _, release := range sorted {
+		if keep[release.Tag] || (release.Withdrawn && !pruneWithdrawn) {
+			continue
+		}
+		if p.KeepNewerThan > 0 && (release.PublishedAt == nil || now.Sub(*release.PublishedAt) < p.KeepNewerThan) {
+			continue
+		}
+		out[release.Tag] = true
+	}
+	return out
+}
+
+// pruneReleases applies the retention policy and handles releases that have
+// disappeared upstream.  Upstream deletions are only detected when complete
+// is true, i.e. when every release was listed.
+func (m *Mirror) pruneReleases(ctx context.Context, complete bool) error {
+	logger := zerolog.Ctx(ctx)
+
+	var firstErr error
+	remove := func(release *indexfile.Release, action string, reason string) bool {
+		releaseLogger := logger.With().
+			Int64("releaseID", release.ID).
+			Str("releaseTag", release.Tag).
+			Str("action", action).
+			Logger()
+		ctx2 := releaseLogger.WithContext(ctx)
+
+		err := m.removeRelease(ctx2, release, action)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return false
+		}
+		releaseLogger.Info().
+			Msg(reason)
+		return true
+	}
+
+	kept := make([]indexfile.Release, 0, len(m.releases))
+	for _, release := range m.releases {
+		if complete && !m.seenTags[release.Tag] {
+			switch m.Retention.OnUpstreamDelete {
+			case WithdrawRelease:
+				if !release.Withdrawn {
+					logger.Info().
+						Int64("releaseID", release.ID).
+						Str("releaseTag", release.Tag).
+						Msg("release was deleted upstream; marking it as withdrawn")
+				}
+				release.Withdrawn = true
+			default:
+				if remove(&release, m.Retention.OnUpstreamDelete, "release was deleted upstream; pruned it from the mirror") {
+					continue
+				}
+			}
+		}
+		kept = append(kept, release)
+	}
+	m.releases = kept
+
+	expired := m.Retention.Expired(m.releases, time.Now())
+	kept = make([]indexfile.Release, 0, len(m.releases))
+	for _, release := range m.releases {
+		if expired[release.Tag] && remove(&release, m.Retention.OnExpire, "release is outside the retention policy; pruned it from the mirror") {
+			continue
+		}
+		kept = append(kept, release)
+	}
+	m.releases = kept
+
+	m.releaseIndexByTag = make(map[string]uint, len(m.releases))
+	for index, release := range m.releases {
+		m.releaseIndexByTag[release.Tag] = uint(index)
+	}
+	return firstErr
+}
+
+// removeRelease moves a release's files into the attic, or deletes them,
+// along with any partial downloads and quarantined files.
+func (m *Mirror) removeRelease(ctx context.Context, release *indexfile.Release, action string) error {
+	logger := zerolog.Ctx(ctx)
+
+	releaseDir := filepath.Join(m.OutputDir, release.Tag)
+	removeDirs := []string{
+		filepath.Join(m.OutputDir, StagingDirName, release.Tag),
+		filepath.Join(m.OutputDir, QuarantineDirName, release.Tag),
+	}
+
+	switch action {
+	case AtticRelease:
+		atticDir := filepath.Join(m.OutputDir, AtticDirName, release.Tag)
+		_, err := os.Stat(releaseDir)
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		err = os.RemoveAll(atticDir)
+		if err == nil {
+			err = indexutil.RenameFile(ctx, releaseDir, atticDir, 0o777)
+		}
+		if err != nil {
+			logger.Error().
+				Str("path", releaseDir).
+				Str("atticPath", atticDir).
+				Err(err).
+				Msg("failed to move release directory to the attic")
+			return err
+		}
+	default:
+		removeDirs = append(removeDirs, releaseDir)
+	}
+
+	for _, dir := range removeDirs {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			logger.Error().
+				Str("path", dir).
+				Err(err).
+				Msg("failed to remove release directory")
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/chronos-tachyon/github-asset-mirror/indexfile"
+)
+
+func TestRetentionPolicy_Expired(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	release := func(tag string, age time.Duration, withdrawn bool) indexfile.Release {
+		var v indexfile.Version
+		if !v.Parse(tag) {
+			t.Fatalf("failed to parse %q", tag)
+		}
+		publishedAt := now.Add(-age)
+		return indexfile.Release{Tag: tag, Version: v, PublishedAt: &publishedAt, Withdrawn: withdrawn}
+	}
+
+	const day = 24 * time.Hour
+	releases := []indexfile.Release{
+		release("v0.9.0", 400*day, false),
+		release("v1.0.0", 300*day, true),
+		release("v1.1.0", 200*day, false),
+		release("v2.0.0", 100*day, false),
+		release("v2.1.0", 50*day, false),
+		release("v3.0.0", 1*day, false),
+	}
+
+	type testRow struct {
+		Name     string
+		Policy   RetentionPolicy
+		Expected []string
+	}
+
+	testData := [...]testRow{
+		{
+			Name:     "disabled",
+			Policy:   RetentionPolicy{},
+			Expected: nil,
+		},
+		{
+			Name:     "keepLast",
+			Policy:   RetentionPolicy{KeepLast: 2},
+			Expected: []string{"v0.9.0", "v1.1.0", "v2.0.0"},
+		},
+		{
+			Name:     "keepLast skips withdrawn when counting",
+			Policy:   RetentionPolicy{KeepLast: 4},
+			Expected: []string{"v0.9.0"},
+		},
+		{
+			Name:     "keepPerMajor",
+			Policy:   RetentionPolicy{KeepPerMajor: 1},
+			Expected: []string{"v2.0.0"},
+		},
+		{
+			Name:     "keepNewerThan",
+			Policy:   RetentionPolicy{KeepNewerThan: 150 * day},
+			Expected: []string{"v0.9.0", "v1.1.0"},
+		},
+		{
+			Name:     "union of rules",
+			Policy:   RetentionPolicy{KeepLast: 1, KeepPerMajor: 1, KeepNewerThan: 60 * day},
+			Expected: []string{"v2.0.0"},
+		},
+		{
+			Name:     "withdrawn kept by default",
+			Policy:   RetentionPolicy{KeepLast: 1}.WithDefaults(),
+			Expected: []string{"v0.9.0", "v1.1.0", "v2.0.0", "v2.1.0"},
+		},
+		{
+			Name:     "withdrawn pruned with onUpstreamDelete attic",
+			Policy:   RetentionPolicy{KeepLast: 1, OnUpstreamDelete: AtticRelease},
+			Expected: []string{"v0.9.0", "v1.0.0", "v1.1.0", "v2.0.0", "v2.1.0"},
+		},
+		{
+			Name:     "withdrawn pruned with onUpstreamDelete delete",
+			Policy:   RetentionPolicy{KeepPerMajor: 1, OnUpstreamDelete: DeleteRelease},
+			Expected: []string{"v1.0.0", "v2.0.0"},
+		},
+	}
+
+	for _, row := range testData {
+		t.Run(row.Name, func(t *testing.T) {
+			expired := row.Policy.Expired(releases, now)
+			actual := make([]string, 0, len(expired))
+			for tag := range expired {
+				actual = append(actual, tag)
+			}
+			sort.Strings(actual)
+			if len(actual) != len(row.Expected) {
+				t.Fatalf("expected %q, got %q", row.Expected, actual)
+			}
+			for index := range actual {
+				if actual[index] != row.Expected[index] {
+					t.Fatalf("expected %q, got %q", row.Expected, actual)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/go-github/v48/github"
+	"github.com/rs/zerolog"
+)
+
+const (
+	DefaultMaxAttempts  = 5
+	DefaultInitialDelay = 1 * time.Second
+	DefaultMaxDelay     = 60 * time.Second
+	DefaultMultiplier   = 2.0
+	DefaultJitter       = 0.2
+)
+
+var (
+	gRandMutex sync.Mutex
+	gRand      = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+type RetryPolicy struct {
+	MaxAttempts  int           `yaml:"maxAttempts,omitempty"`
+	InitialDelay time.Duration `yaml:"initialDelay,omitempty"`
+	MaxDelay     time.Duration `yaml:"maxDelay,omitempty"`
+	Multiplier   float64       `yaml:"multiplier,omitempty"`
+	Jitter       float64       `yaml:"jitter,omitempty"`
+}
+
+func (p RetryPolicy) Validate() error {
+	if p.MaxAttempts < 0 {
+		return fmt.Errorf("retry.maxAttempts: must not be negative, got %d", p.MaxAttempts)
+	}
+	if p.InitialDelay < 0 {
+		return fmt.Errorf("retry.initialDelay: must not be negative, got %v", p.InitialDelay)
+	}
+	if p.MaxDelay < 0 {
+		return fmt.Errorf("retry.maxDelay: must not be negative, got %v", p.MaxDelay)
+	}
+	if p.Multiplier != 0 && p.Multiplier < 1 {
+		return fmt.Errorf("retry.multiplier: must be at least 1, got %g", p.Multiplier)
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("retry.jitter: must be between 0 and 1, got %g", p.Jitter)
+	}
+	return nil
+}
+
+// WithDefaults returns a copy of p with all unset fields filled in.
+func (p RetryPolicy) WithDefaults() RetryPolicy {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = DefaultMaxAttempts
+	}
+	if p.InitialDelay == 0 {
+		p.InitialDelay = DefaultInitialDelay
+	}
+	if p.MaxDelay == 0 {
+		p.MaxDelay = DefaultMaxDelay
+	}
+	if p.Multiplier == 0 {
+		p.Multiplier = DefaultMultiplier
+	}
+	if p.Jitter == 0 {
+		p.Jitter = DefaultJitter
+	}
+	return p
+}
+
+// Delay returns how long to wait before the given retry, where retry 1 is the
+// second attempt.
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	delay := float64(p.InitialDelay)
+	for i := 1; i < retry && delay < float64(p.MaxDelay); i++ {
+		delay *= p.Multiplier
+	}
+	if delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	gRandMutex.Lock()
+	r := gRand.Float64()
+	gRandMutex.Unlock()
+
+	delay *= 1 + p.Jitter*(2*r-1)
+	return time.Duration(delay)
+}
+
+// Do calls fn until it succeeds, returns a permanent error, or the policy's
+// attempts are exhausted.
+func (p RetryPolicy) Do(ctx context.Context, what string, fn func() error) error {
+	logger := zerolog.Ctx(ctx)
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !IsRetryable(err) {
+			logger.Error().
+				Int("attempt", attempt).
+				Err(err).
+				Msgf("failed to %s", what)
+			return err
+		}
+		if attempt >= p.MaxAttempts {
+			logger.Error().
+				Int("attempt", attempt).
+				Err(err).
+				Msgf("failed to %s; giving up after too many attempts", what)
+			return err
+		}
+
+		delay := p.Delay(attempt)
+		var statusErr *HTTPStatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > delay {
+			delay = statusErr.RetryAfter
+		}
+		logger.Warn().
+			Int("attempt", attempt).
+			Dur("delay", delay).
+			Err(err).
+			Msgf("transient failure while trying to %s; retrying", what)
+
+		err = sleepContext(ctx, delay)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+type HTTPStatusError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Status     string
+	RetryAfter time.Duration
+}
+
+func NewHTTPStatusError(resp *http.Response) *HTTPStatusError {
+	var retryAfter time.Duration
+	if str := resp.Header.Get("retry-after"); str != "" {
+		if secs, err := strconv.ParseUint(str, 10, 32); err == nil {
+			retryAfter = time.Duration(secs) * time.Second
+		} else if t, err := http.ParseTime(str); err == nil {
+			retryAfter = time.Until(t)
+		}
+	}
+	return &HTTPStatusError{
+		Method:     resp.Request.Method,
+		URL:        resp.Request.URL.String(),
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		RetryAfter: retryAfter,
+	}
+}
+
+func (err *HTTPStatusError) Error() string {
+	return fmt.Sprintf("%s %s: unexpected HTTP status %s", err.Method, err.URL, err.Status)
+}
+
+func isRetryableStatus(code int) bool {
+	switch {
+	case code == http.StatusRequestTimeout:
+		return true
+	case code == http.StatusTooManyRequests:
+		return true
+	case code >= 500 && code <= 599 && code != http.StatusNotImplemented:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsRetryable classifies err as either transient (worth retrying) or
+// permanent.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return isRetryableStatus(statusErr.StatusCode)
+	}
+
+	var ghErr *github.ErrorResponse
+	if errors.As(err, &ghErr) {
+		if ghErr.Response == nil {
+			return false
+		}
+		return isRetryableStatus(ghErr.Response.StatusCode)
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
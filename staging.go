@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/rs/zerolog"
+
+	"github.com/chronos-tachyon/github-asset-mirror/indexutil"
+)
+
+// StagingDirName is the directory beneath each repository's output directory
+// where partially downloaded assets are kept between runs.
+const StagingDirName = ".partial"
+
+const stagingStateSuffix = ".state.json"
+
+var reContentRange = regexp.MustCompile(`^bytes ([0-9]+)-([0-9]+)/([0-9]+|\*)$`)
+
+type stagingState struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Size         int64  `json:"size,omitempty"`
+}
+
+func (state stagingState) Validator() string {
+	if state.ETag != "" {
+		return state.ETag
+	}
+	return state.LastModified
+}
+
+type stagedFile struct {
+	URL       string
+	DataPath  string
+	StatePath string
+	Mode      fs.FileMode
+	SHA512    bool
+	State     stagingState
+	Offset    int64
+	Digest    *indexutil.Digester
+}
+
+func (m *Mirror) stagedFileFor(releaseTag string, assetName string, assetURL string, mode fs.FileMode) *stagedFile {
+	dataPath := filepath.Join(m.OutputDir, StagingDirName, releaseTag, assetName)
+	return &stagedFile{
+		URL:       assetURL,
+		DataPath:  dataPath,
+		StatePath: dataPath + stagingStateSuffix,
+		Mode:      mode,
+		SHA512:    m.SHA512,
+	}
+}
+
+// Load reads any previously staged data for the asset.  If the staged data
+// cannot be resumed, it is discarded and the download starts from zero.
+func (sf *stagedFile) Load(ctx context.Context) {
+	logger := zerolog.Ctx(ctx)
+
+	sf.State = stagingState{URL: sf.URL}
+	sf.Offset = 0
+	sf.Digest = indexutil.NewDigester(sf.SHA512)
+
+	raw, err := os.ReadFile(sf.StatePath)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			logger.Warn().
+				Str("path", sf.StatePath).
+				Err(err).
+				Msg("failed to read staging state file; restarting download")
+		}
+		sf.Discard()
+		return
+	}
+
+	var state stagingState
+	if err := indexutil.FromJSON(ctx, &state, raw); err != nil || state.URL != sf.URL || state.Validator() == "" {
+		sf.Discard()
+		return
+	}
+
+	fi, err := os.Stat(sf.DataPath)
+	if err != nil || !fi.Mode().IsRegular() || (state.Size > 0 && fi.Size() > state.Size) {
+		sf.Discard()
+		return
+	}
+
+	// Rebuild the digests of the data that is already staged, so that the
+	// final digests cover the whole file.
+	digest, err := indexutil.DigestFile(sf.DataPath, sf.SHA512)
+	if err != nil {
+		logger.Warn().
+			Str("path", sf.DataPath).
+			Err(err).
+			Msg("failed to read staged data; restarting download")
+		sf.Discard()
+		return
+	}
+
+	sf.State = state
+	sf.Offset = digest.Size()
+	sf.Digest = digest
+}
+
+func (sf *stagedFile) Discard() {
+	_ = os.Remove(sf.DataPath)
+	_ = os.Remove(sf.StatePath)
+	sf.State = stagingState{URL: sf.URL}
+	sf.Offset = 0
+	sf.Digest = indexutil.NewDigester(sf.SHA512)
+}
+
+func (sf *stagedFile) SaveState(ctx context.Context) error {
+	raw := indexutil.ToJSON(ctx, sf.State)
+	return indexutil.WriteFile(ctx, sf.StatePath, raw, 0o666)
+}
+
+// PrepareRequest adds the headers needed to resume the staged download.
+func (sf *stagedFile) PrepareRequest(req *http.Request) {
+	if sf.Offset <= 0 {
+		return
+	}
+	req.Header.Set("range", "bytes="+strconv.FormatInt(sf.Offset, 10)+"-")
+	req.Header.Set("if-range", sf.State.Validator())
+}
+
+// AcceptResponse reconciles the staged data with the server's response.  It
+// returns false if the response cannot be used to continue the download and
+// the request should be retried from byte zero.
+func (sf *stagedFile) AcceptResponse(resp *http.Response) (bool, error) {
+	switch resp.StatusCode {
+	case http.StatusOK:
+		etag := resp.Header.Get("etag")
+		lastModified := resp.Header.Get("last-modified")
+		sf.Discard()
+		sf.State.ETag = etag
+		sf.State.LastModified = lastModified
+		sf.State.Size = resp.ContentLength
+		if sf.State.Size < 0 {
+			sf.State.Size = 0
+		}
+		return true, nil
+
+	case http.StatusPartialContent:
+		if sf.Offset <= 0 {
+			return false, nil
+		}
+		match := reContentRange.FindStringSubmatch(resp.Header.Get("content-range"))
+		if match == nil {
+			return false, nil
+		}
+		start, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || start != sf.Offset {
+			return false, nil
+		}
+		if match[3] != "*" {
+			total, err := strconv.ParseInt(match[3], 10, 64)
+			if err != nil || (sf.State.Size > 0 && total != sf.State.Size) {
+				return false, nil
+			}
+			sf.State.Size = total
+		}
+		if etag := resp.Header.Get("etag"); etag != "" && sf.State.ETag != "" && etag != sf.State.ETag {
+			return false, nil
+		}
+		return true, nil
+
+	case http.StatusRequestedRangeNotSatisfiable:
+		if sf.Offset > 0 && sf.Offset == sf.State.Size {
+			return true, nil
+		}
+		return false, nil
+
+	default:
+		return false, NewHTTPStatusError(resp)
+	}
+}
+
+// Append writes r to the end of the staged data and syncs it to disk.
+func (sf *stagedFile) Append(ctx context.Context, r io.Reader) error {
+	logger := zerolog.Ctx(ctx)
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if sf.Offset <= 0 {
+		flags |= os.O_TRUNC
+	}
+
+	file, err := os.OpenFile(sf.DataPath, flags, sf.Mode)
+	if err != nil {
+		logger.Error().
+			Str("path", sf.DataPath).
+			Err(err).
+			Msg("failed to open staging file")
+		return err
+	}
+
+	_, err = file.Seek(sf.Offset, io.SeekStart)
+	if err != nil {
+		_ = file.Close()
+		logger.Error().
+			Str("path", sf.DataPath).
+			Int64("offset", sf.Offset).
+			Err(err).
+			Msg("failed to seek within staging file")
+		return err
+	}
+
+	n, err := io.Copy(file, io.TeeReader(r, sf.Digest))
+	sf.Offset += n
+	if err2 := file.Sync(); err == nil {
+		err = err2
+	}
+	if err2 := file.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Error().
+				Str("path", sf.DataPath).
+				Int64("bytesWritten", n).
+				Err(err).
+				Msg("I/O error while writing to staging file")
+		}
+		return err
+	}
+	return nil
+}
+
+// Complete reports whether the staged data holds the entire asset.
+func (sf *stagedFile) Complete() bool {
+	return sf.State.Size <= 0 || sf.Offset == sf.State.Size
+}
+
+// Commit moves the fully downloaded data to its permanent home.
+func (sf *stagedFile) Commit(ctx context.Context, filePath string) error {
+	err := indexutil.RenameFile(ctx, sf.DataPath, filePath, sf.Mode)
+	if err == nil {
+		_ = os.Remove(sf.StatePath)
+	}
+	return err
+}
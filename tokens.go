@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+// NewTokenSource works out how to authenticate to the GitHub instance
+// hosting repo.  Explicitly configured credentials are tried first, in this
+// order: the repo's tokenFile, then the host's app, tokenFile, tokenCommand
+// or anonymous setting.  Failing those, the token is taken from the
+// environment, and then from the gh CLI's hosts.yml.  A nil TokenSource
+// means that requests are sent without credentials.
+func NewTokenSource(ctx context.Context, cfg *Config, repo *RepoConfig, transport http.RoundTripper) (TokenSource, error) {
+	logger := zerolog.Ctx(ctx)
+	host := cfg.RepoHost(repo)
+	githubURL := cfg.RepoGitHubURL(repo)
+	hostName := HostName(githubURL)
+
+	switch {
+	case repo.TokenFile != "":
+		return ReadTokenFile(repo.TokenFile)
+
+	case host.App != nil:
+		return NewAppTokenSource(ctx, host.App, transport, githubURL, host.UploadURL, repo.Owner, repo.Repo)
+
+	case host.TokenFile != "":
+		return ReadTokenFile(host.TokenFile)
+
+	case len(host.TokenCommand) != 0:
+		return RunTokenCommand(ctx, host.TokenCommand, hostName)
+
+	case host.Anonymous:
+		logger.Info().
+			Msg("accessing GitHub anonymously; the API rate limit is 60 requests per hour")
+		return nil, nil
+	}
+
+	for _, name := range tokenEnvVars(hostName) {
+		if token := strings.TrimSpace(os.Getenv(name)); token != "" {
+			logger.Debug().
+				Str("envVar", name).
+				Msg("using GitHub access token from environment")
+			return StaticToken(token), nil
+		}
+	}
+
+	token, hostsFile, err := ReadGHToken(hostName)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		logger.Debug().
+			Str("hostsFile", hostsFile).
+			Msg("using GitHub access token from gh CLI config")
+		return StaticToken(token), nil
+	}
+
+	return nil, fmt.Errorf("no GitHub credentials found for %s; configure tokenFile, app or tokenCommand, set %s, log in with \"gh auth login\", or set anonymous: true", hostName, strings.Join(tokenEnvVars(hostName), " or "))
+}
+
+// ReadTokenFile reads a personal access token from a file.
+func ReadTokenFile(tokenFile string) (StaticToken, error) {
+	raw, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", err
+	}
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return "", fmt.Errorf("%s: file is empty", tokenFile)
+	}
+	return StaticToken(raw), nil
+}
+
+// RunTokenCommand runs a credential helper and returns the token it prints
+// on stdout.  The helper is told which host the token is for via the
+// GITHUB_HOST environment variable.
+func RunTokenCommand(ctx context.Context, argv []string, hostName string) (StaticToken, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), "GITHUB_HOST="+ghHostName(hostName))
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("tokenCommand %q: %w: %s", argv[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	token := strings.TrimSpace(stdout.String())
+	if token == "" {
+		return "", fmt.Errorf("tokenCommand %q: printed no token", argv[0])
+	}
+	return StaticToken(token), nil
+}
+
+// tokenEnvVars returns the environment variables that may hold a token for
+// the given API host, following the gh CLI's conventions.
+func tokenEnvVars(hostName string) []string {
+	if ghHostName(hostName) == "github.com" {
+		return []string{"GH_TOKEN", "GITHUB_TOKEN"}
+	}
+	return []string{"GH_ENTERPRISE_TOKEN", "GITHUB_ENTERPRISE_TOKEN"}
+}
+
+// ghHostName maps an API host name to the name the gh CLI uses for it.
+func ghHostName(hostName string) string {
+	if hostName == HostName(DefaultGitHubURL) {
+		return "github.com"
+	}
+	return hostName
+}
+
+// ghHostsFile returns the path of the gh CLI's hosts.yml.
+func ghHostsFile() string {
+	if dir := os.Getenv("GH_CONFIG_DIR"); dir != "" {
+		return filepath.Join(dir, "hosts.yml")
+	}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "gh", "hosts.yml")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "gh", "hosts.yml")
+}
+
+// ReadGHToken returns the OAuth token stored by "gh auth login" for the given
+// API host, if any.  Tokens that gh keeps in the system keyring are not
+// visible here.
+func ReadGHToken(hostName string) (token string, hostsFile string, err error) {
+	hostsFile = ghHostsFile()
+	if hostsFile == "" {
+		return "", "", nil
+	}
+
+	raw, err := os.ReadFile(hostsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", hostsFile, nil
+	}
+	if err != nil {
+		return "", hostsFile, err
+	}
+
+	var hosts map[string]struct {
+		OAuthToken string `yaml:"oauth_token"`
+	}
+	err = yaml.Unmarshal(raw, &hosts)
+	if err != nil {
+		return "", hostsFile, fmt.Errorf("%s: %w", hostsFile, err)
+	}
+	return strings.TrimSpace(hosts[ghHostName(hostName)].OAuthToken), hostsFile, nil
+}
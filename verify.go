@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog"
+
+	"github.com/chronos-tachyon/github-asset-mirror/indexfile"
+	"github.com/chronos-tachyon/github-asset-mirror/indexutil"
+)
+
+// Kinds of problem found by VerifyRepo.
+const (
+	MissingProblem    = "missing"
+	UnreadableProblem = "unreadable"
+	ModeProblem       = "mode"
+	SizeProblem       = "size"
+	DigestProblem     = "digest"
+	ChecksumProblem   = "checksum"
+	ProvenanceProblem = "provenance"
+	SignatureProblem  = "signature"
+	MalformedProblem  = "malformed"
+)
+
+// VerifyReport is the machine-readable result of the verify command.
+type VerifyReport struct {
+	NumFiles int             `json:"numFiles"`
+	Problems []VerifyProblem `json:"problems"`
+}
+
+// VerifyProblem describes one problem with one file of the mirror.
+type VerifyProblem struct {
+	Repo     string `json:"repo"`
+	Release  string `json:"release,omitempty"`
+	Asset    string `json:"asset,omitempty"`
+	Path     string `json:"path"`
+	Problem  string `json:"problem"`
+	Detail   string `json:"detail,omitempty"`
+	Repaired bool   `json:"repaired,omitempty"`
+}
+
+// Repairable reports whether downloading the file again may fix the
+// problem.
+func (p VerifyProblem) Repairable() bool {
+	switch p.Problem {
+	case MissingProblem, UnreadableProblem, ModeProblem, SizeProblem, DigestProblem:
+		return p.Asset != ""
+	default:
+		return false
+	}
+}
+
+// VerifyRepo checks every mirrored file of repo against index.json: that it
+// exists with the expected mode, and that its size and digests match.  The
+// release's checksum and provenance files are checked against the files
+// they describe.  With repair, files that are missing or damaged are deleted
+// and downloaded again, and the problems that this fixed are marked as
+// repaired.  It returns the number of files checked and every problem found.
+func VerifyRepo(ctx context.Context, cfg *Config, repo *RepoConfig, repair bool) (int, []VerifyProblem, error) {
+	var m *Mirror
+	var err error
+	if repair {
+		m, err = NewMirror(ctx, cfg, repo)
+	} else {
+		m, err = newOfflineMirror(ctx, cfg, repo)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	logger := zerolog.Ctx(ctx).With().
+		Str("githubOwner", m.Owner).
+		Str("githubRepo", m.Repo).
+		Str("outputDir", m.OutputDir).
+		Logger()
+	ctx = logger.WithContext(ctx)
+
+	err = m.loadIndex(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	numFiles, problems := m.verifyFiles(ctx)
+	if !repair || len(problems) == 0 {
+		return numFiles, problems, nil
+	}
+
+	numRepairable := 0
+	m.refetch = make(map[string]bool, len(problems))
+	for _, problem := range problems {
+		if !problem.Repairable() {
+			continue
+		}
+		releaseIndex, found := m.releaseIndexByTag[problem.Release]
+		if !found || m.releases[releaseIndex].Withdrawn {
+			// Withdrawn releases can no longer be fetched upstream.
+			continue
+		}
+		asset := m.findAsset(problem.Release, problem.Asset)
+		if asset == nil {
+			continue
+		}
+		if asset.Derived() {
+			// Files extracted from an archive are never downloaded;
+			// forget which archive contents they came from, so that
+			// the archive is unpacked again.
+			if !m.ExtractArchives {
+				continue
+			}
+			asset.ParentSHA256 = ""
+		} else {
+			m.refetch[problem.Path] = true
+		}
+		numRepairable++
+	}
+	if numRepairable == 0 {
+		return numFiles, problems, nil
+	}
+
+	logger.Info().
+		Int("numFiles", numRepairable).
+		Msg("downloading damaged files again")
+	syncErr := m.syncAssets(ctx)
+
+	// A file counts as repaired only if nothing at all is wrong with it
+	// any more: a damaged file that was replaced by a copy which is wrong
+	// in some other way has not been fixed.
+	numFiles, remaining := m.verifyFiles(ctx)
+	stillBroken := make(map[string]bool, len(remaining))
+	for _, problem := range remaining {
+		stillBroken[problem.Path] = true
+	}
+
+	out := make([]VerifyProblem, 0, len(problems)+len(remaining))
+	seen := make(map[verifyProblemKey]bool, len(problems))
+	for _, problem := range problems {
+		seen[problem.key()] = true
+		problem.Repaired = problem.Repairable() && !stillBroken[problem.Path]
+		out = append(out, problem)
+	}
+	for _, problem := range remaining {
+		if !seen[problem.key()] {
+			out = append(out, problem)
+		}
+	}
+	return numFiles, out, syncErr
+}
+
+type verifyProblemKey struct {
+	Path    string
+	Problem string
+}
+
+func (p VerifyProblem) key() verifyProblemKey {
+	return verifyProblemKey{Path: p.Path, Problem: p.Problem}
+}
+
+// newOfflineMirror returns a Mirror that can read and check the local copy
+// of repo, but not talk to GitHub.
+func newOfflineMirror(ctx context.Context, cfg *Config, repo *RepoConfig) (*Mirror, error) {
+	var provenanceKey crypto.PublicKey
+	if repo.ProvenancePublicKey != "" {
+		var err error
+		provenanceKey, err = LoadPublicKey(repo.ProvenancePublicKey)
+		if err != nil {
+			zerolog.Ctx(ctx).Error().
+				Str("keyFile", repo.ProvenancePublicKey).
+				Err(err).
+				Msg("failed to load provenance public key")
+			return nil, err
+		}
+	}
+
+	m := &Mirror{
+		Owner:         repo.Owner,
+		Repo:          repo.Repo,
+		OutputDir:     cfg.RepoOutputDir(repo),
+		SHA512:        cfg.SHA512,
+		ProvenanceKey: provenanceKey,
+	}
+	return m, nil
+}
+
+func (m *Mirror) findAsset(tag string, name string) *indexfile.Asset {
+	releaseIndex, found := m.releaseIndexByTag[tag]
+	if !found {
+		return nil
+	}
+	release := &m.releases[releaseIndex]
+	for assetIndex := range release.Assets {
+		if release.Assets[assetIndex].Name == name {
+			return &release.Assets[assetIndex]
+		}
+	}
+	return nil
+}
+
+// verifyFiles re-hashes every mirrored file and returns the number of files
+// checked and the problems found.  Quarantined assets, and assets excluded
+// by the filters, have no file to check.
+func (m *Mirror) verifyFiles(ctx context.Context) (int, []VerifyProblem) {
+	logger := zerolog.Ctx(ctx)
+
+	numFiles := 0
+	problems := make([]VerifyProblem, 0, 16)
+	for _, release := range m.releases {
+		releaseDir := filepath.Join(m.OutputDir, release.Tag)
+
+		report := func(assetName string, assetPath string, kind string, detail string) {
+			logger.Warn().
+				Str("releaseTag", release.Tag).
+				Str("assetPath", assetPath).
+				Str("problem", kind).
+				Str("detail", detail).
+				Msg("mirrored file failed verification")
+			problems = append(problems, VerifyProblem{
+				Repo:    m.Owner + "/" + m.Repo,
+				Release: release.Tag,
+				Asset:   assetName,
+				Path:    assetPath,
+				Problem: kind,
+				Detail:  detail,
+			})
+		}
+
+		digests := make(map[string]*indexutil.Digester, len(release.Assets))
+		for _, asset := range release.Assets {
+			if asset.NotMirrored || asset.Quarantined() {
+				continue
+			}
+
+			assetPath := filepath.Join(releaseDir, filepath.FromSlash(asset.Name))
+			info, err := os.Lstat(assetPath)
+			if errors.Is(err, fs.ErrNotExist) {
+				report(asset.Name, assetPath, MissingProblem, "")
+				continue
+			}
+			if err != nil {
+				report(asset.Name, assetPath, UnreadableProblem, err.Error())
+				continue
+			}
+			if !info.Mode().IsRegular() {
+				report(asset.Name, assetPath, ModeProblem, fmt.Sprintf("not a regular file: %v", info.Mode()))
+				continue
+			}
+
+			numFiles++
+			wantExec := asset.Mode()&0o111 != 0
+			haveExec := info.Mode().Perm()&0o111 != 0
+			if wantExec != haveExec {
+				report(asset.Name, assetPath, ModeProblem, fmt.Sprintf("mode is %03o, expected %03o less umask", info.Mode().Perm(), asset.Mode()))
+			}
+
+			digest, err := indexutil.DigestFile(assetPath, true)
+			if err != nil {
+				report(asset.Name, assetPath, UnreadableProblem, err.Error())
+				continue
+			}
+			if asset.SHA256 != "" || asset.SHA512 != "" {
+				if digest.Size() != asset.Size {
+					report(asset.Name, assetPath, SizeProblem, fmt.Sprintf("size is %d bytes, index says %d", digest.Size(), asset.Size))
+					continue
+				}
+				if (asset.SHA256 != "" && digest.SHA256() != asset.SHA256) || (asset.SHA512 != "" && digest.SHA512() != asset.SHA512) {
+					report(asset.Name, assetPath, DigestProblem, "contents do not match the digests in the index")
+					continue
+				}
+			}
+			digests[asset.Name] = digest
+		}
+
+		for _, asset := range release.Assets {
+			if digests[asset.Name] == nil {
+				continue
+			}
+			assetPath := filepath.Join(releaseDir, filepath.FromSlash(asset.Name))
+
+			switch asset.Type {
+			case indexfile.ChecksumType:
+				raw, err := os.ReadFile(assetPath)
+				if err != nil {
+					report(asset.Name, assetPath, UnreadableProblem, err.Error())
+					continue
+				}
+				defaultName := ""
+				if match := reChecksumName.FindStringSubmatch(asset.Name); match != nil {
+					defaultName = match[1]
+				}
+				list, err := ParseChecksumFile(raw, defaultName)
+				if err != nil {
+					report(asset.Name, assetPath, MalformedProblem, err.Error())
+					continue
+				}
+				for _, entry := range list {
+					digest := digests[entry.Name]
+					if digest == nil {
+						continue
+					}
+					if (entry.SHA256 != "" && entry.SHA256 != digest.SHA256()) || (entry.SHA512 != "" && entry.SHA512 != digest.SHA512()) {
+						report(entry.Name, filepath.Join(releaseDir, filepath.FromSlash(entry.Name)), ChecksumProblem, "does not match "+asset.Name)
+					}
+				}
+
+			case indexfile.ProvenanceType:
+				raw, err := os.ReadFile(assetPath)
+				if err != nil {
+					report(asset.Name, assetPath, UnreadableProblem, err.Error())
+					continue
+				}
+				envs, stmts, payloads, err := ParseProvenanceFile(raw)
+				if err != nil {
+					report(asset.Name, assetPath, MalformedProblem, err.Error())
+					continue
+				}
+				for index := range envs {
+					if m.ProvenanceKey != nil && !envs[index].Verify(m.ProvenanceKey, payloads[index]) {
+						report(asset.Name, assetPath, SignatureProblem, fmt.Sprintf("statement %d is not signed by the configured public key", index))
+						continue
+					}
+					for _, subject := range stmts[index].Subject {
+						digest := digests[subject.Name]
+						if digest == nil {
+							continue
+						}
+						actual := indexfile.Asset{SHA256: digest.SHA256(), SHA512: digest.SHA512()}
+						if !matchSubjectDigest(subject, actual) {
+							report(subject.Name, filepath.Join(releaseDir, filepath.FromSlash(subject.Name)), ProvenanceProblem, "does not match "+asset.Name)
+						}
+					}
+				}
+			}
+		}
+	}
+	return numFiles, problems
+}
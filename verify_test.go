@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chronos-tachyon/github-asset-mirror/indexfile"
+	"github.com/chronos-tachyon/github-asset-mirror/indexutil"
+)
+
+func TestVerifyRepo_Repair(t *testing.T) {
+	const goodData = "good contents\n"
+	const badData = "bad contents!\n"
+
+	type testRow struct {
+		Name             string
+		StatusCode       int
+		ExpectedData     string
+		ExpectedRepaired bool
+	}
+
+	testData := [...]testRow{
+		{"download-succeeds", http.StatusOK, goodData, true},
+		{"download-fails", http.StatusNotFound, badData, false},
+	}
+
+	for _, row := range testData {
+		t.Run(row.Name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/api/v3/repos/owner/repo/releases/assets/42" {
+					http.NotFound(w, r)
+					return
+				}
+				if row.StatusCode != http.StatusOK {
+					http.Error(w, "nope", row.StatusCode)
+					return
+				}
+				_, _ = w.Write([]byte(goodData))
+			}))
+			defer ts.Close()
+
+			ctx := context.Background()
+			outputDir := t.TempDir()
+			cfg := &Config{
+				OutputDir: outputDir,
+				GitHubURL: ts.URL,
+				Anonymous: true,
+				Retry:     RetryPolicy{MaxAttempts: 1},
+			}
+			repo := &RepoConfig{Owner: "owner", Repo: "repo", OutputDir: "."}
+
+			digest := indexutil.NewDigester(false)
+			_, _ = digest.Write([]byte(goodData))
+			releases := []indexfile.Release{{
+				ID:  1,
+				Tag: "v1.0.0",
+				Assets: []indexfile.Asset{{
+					ID:     42,
+					Name:   "tool.txt",
+					URL:    ts.URL + "/download/tool.txt",
+					Size:   digest.Size(),
+					SHA256: digest.SHA256(),
+				}},
+			}}
+			err := indexutil.WriteFile(ctx, filepath.Join(outputDir, indexfile.IndexFileName), indexutil.ToJSON(ctx, releases), 0o666)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assetPath := filepath.Join(outputDir, "v1.0.0", "tool.txt")
+			err = indexutil.WriteFile(ctx, assetPath, []byte(badData), 0o666)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, problems, _ := VerifyRepo(ctx, cfg, repo, true)
+			if len(problems) != 1 || problems[0].Problem != DigestProblem || problems[0].Repaired != row.ExpectedRepaired {
+				t.Errorf("expected one digest problem with repaired=%v, got %+v", row.ExpectedRepaired, problems)
+			}
+
+			raw, err := os.ReadFile(assetPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(raw) != row.ExpectedData {
+				t.Errorf("expected file to contain %q, got %q", row.ExpectedData, raw)
+			}
+		})
+	}
+}